@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Privacy modes
+const (
+	AccessPublic   = "public"   // Listed and open to everyone
+	AccessUnlisted = "unlisted" // Open to anyone who has the screening ID
+	AccessPasscode = "passcode" // Requires the passcode or an invite
+	AccessInvite   = "invite"   // Requires an invite link
+)
+
+// Invite scopes
+const (
+	ScopeTheater  = "theater"
+	ScopeSchedule = "schedule"
+)
+
+// AccessPolicy controls who may join the screenings of a theater or schedule
+type AccessPolicy struct {
+	Mode         string `json:"mode"`
+	PasscodeHash string `json:"-"`
+}
+
+// Invite is the server-side record behind a signed invite link. The link's
+// token carries the invite ID and expiry; uses are counted here.
+type Invite struct {
+	ID          string    `json:"id"`
+	Scope       string    `json:"scope"`
+	ScopeID     string    `json:"scope_id"`
+	ScreeningID string    `json:"screening_id"` // Screening the link opens
+	MaxUses     int       `json:"max_uses"`     // 0 means unlimited
+	Uses        int       `json:"uses"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+var (
+	theaterAccess  = make(map[string]*AccessPolicy)
+	scheduleAccess = make(map[string]*AccessPolicy)
+	invites        = make(map[string]*Invite)
+)
+
+// Get the policy that applies to a screening along with its scope. A
+// schedule's own policy overrides its theater's. Must be called with mu held.
+func accessFor(screening *Screening) (*AccessPolicy, string, string) {
+	if policy, exists := scheduleAccess[screening.ScheduleID]; exists {
+		return policy, ScopeSchedule, screening.ScheduleID
+	}
+	if policy, exists := theaterAccess[screening.TheaterID]; exists {
+		return policy, ScopeTheater, screening.TheaterID
+	}
+	return nil, "", ""
+}
+
+// Whether joining needs a grant in the visitor's token
+func (p *AccessPolicy) private() bool {
+	return p != nil && (p.Mode == AccessPasscode || p.Mode == AccessInvite)
+}
+
+// Whether a screening shows up in public listings. Must be called with mu held.
+func listed(screening *Screening) bool {
+	policy, _, _ := accessFor(screening)
+	return policy == nil || policy.Mode == AccessPublic
+}
+
+func hashPasscode(passcode string) string {
+	return hashIP("passcode:" + passcode)
+}
+
+// Check whether a visitor token's grant covers a screening. Must be called with mu held.
+func hasGrant(claims jwt.MapClaims, screening *Screening) bool {
+	policy, scope, scopeID := accessFor(screening)
+	if !policy.private() {
+		return true
+	}
+	grant, _ := claims["access"].(string)
+	return grant == scope+":"+scopeID
+}
+
+// Check a join against a screening's privacy mode, consuming an invite use if
+// one is presented. Returns the grant to carry in the visitor token, or an
+// HTTP status and error. Must be called with mu held.
+func admit(screening *Screening, passcode, inviteToken string, now time.Time) (string, int, gin.H) {
+	policy, scope, scopeID := accessFor(screening)
+	if !policy.private() {
+		return "", 0, nil
+	}
+	grant := scope + ":" + scopeID
+
+	if inviteToken != "" {
+		invite, err := parseInviteToken(inviteToken)
+		if err != nil || invite.Scope != scope || invite.ScopeID != scopeID || now.After(invite.ExpiresAt) {
+			return "", http.StatusForbidden, gin.H{"error": "Invalid or expired invite", "invite_required": true}
+		}
+		if invite.MaxUses > 0 && invite.Uses >= invite.MaxUses {
+			return "", http.StatusForbidden, gin.H{"error": "Invite has been used up", "invite_required": true}
+		}
+		invite.Uses++
+		return grant, 0, nil
+	}
+
+	if policy.Mode == AccessPasscode {
+		if passcode == "" || !hmac.Equal([]byte(hashPasscode(passcode)), []byte(policy.PasscodeHash)) {
+			return "", http.StatusForbidden, gin.H{"error": "Passcode required", "passcode_required": true}
+		}
+		return grant, 0, nil
+	}
+
+	return "", http.StatusForbidden, gin.H{"error": "Invite required", "invite_required": true}
+}
+
+// Parse a signed invite link token and find its invite. Must be called with mu held.
+func parseInviteToken(tokenString string) (*Invite, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.JWTSecret), nil
+	})
+
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid invite")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["kind"] != "invite" {
+		return nil, fmt.Errorf("not an invite token")
+	}
+
+	inviteID, _ := claims["jti"].(string)
+	invite, exists := invites[inviteID]
+	if !exists {
+		return nil, fmt.Errorf("invite revoked")
+	}
+
+	return invite, nil
+}
+
+// Reject visitor requests to private screenings whose token doesn't carry a grant
+func requireAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		_, screening := screeningParam(c)
+		if policy, _, _ := accessFor(screening); !policy.private() {
+			return
+		}
+
+		claims, err := parseVisitorClaims(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+		if err != nil || !hasGrant(claims, screening) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This screening is private"})
+		}
+	}
+}
+
+// Parse a privacy mode update
+func bindAccessPolicy(c *gin.Context) *AccessPolicy {
+	var request struct {
+		Mode     string `json:"mode" binding:"required"`
+		Passcode string `json:"passcode"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return nil
+	}
+
+	switch request.Mode {
+	case AccessPublic, AccessUnlisted, AccessInvite:
+		return &AccessPolicy{Mode: request.Mode}
+	case AccessPasscode:
+		if request.Passcode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Passcode is required"})
+			return nil
+		}
+		return &AccessPolicy{Mode: request.Mode, PasscodeHash: hashPasscode(request.Passcode)}
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown privacy mode"})
+	return nil
+}
+
+// Set a theater's privacy mode
+func updateTheaterAccess(c *gin.Context) {
+	policy := bindAccessPolicy(c)
+	if policy == nil {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	theaterAccess[c.Param("id")] = policy
+
+	c.JSON(http.StatusOK, gin.H{"theater_id": c.Param("id"), "mode": policy.Mode})
+}
+
+// Set a schedule's privacy mode, overriding its theater's
+func updateScheduleAccess(c *gin.Context) {
+	policy := bindAccessPolicy(c)
+	if policy == nil {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	scheduleAccess[c.Param("id")] = policy
+
+	c.JSON(http.StatusOK, gin.H{"schedule_id": c.Param("id"), "mode": policy.Mode})
+}
+
+// Create an invite link for a screening's theater or schedule
+func createInvite(c *gin.Context) {
+	var request struct {
+		ScreeningID string `json:"screening_id" binding:"required"`
+		Scope       string `json:"scope"`
+		ExpiresIn   string `json:"expires_in"`
+		MaxUses     int    `json:"max_uses"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil || request.MaxUses < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	duration := 7 * 24 * time.Hour
+	if request.ExpiresIn != "" {
+		parsed, err := time.ParseDuration(request.ExpiresIn)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiry"})
+			return
+		}
+		duration = parsed
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	screening, exists := screenings[request.ScreeningID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Screening not found"})
+		return
+	}
+
+	now := time.Now()
+	invite := &Invite{
+		ID:          uuid.New().String(),
+		Scope:       request.Scope,
+		ScreeningID: screening.ID,
+		MaxUses:     request.MaxUses,
+		CreatedBy:   currentOperator(c).Name,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(duration),
+	}
+	switch request.Scope {
+	case "", ScopeSchedule:
+		invite.Scope = ScopeSchedule
+		invite.ScopeID = screening.ScheduleID
+	case ScopeTheater:
+		invite.ScopeID = screening.TheaterID
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown invite scope"})
+		return
+	}
+
+	tokenString, err := signInvite(invite)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate invite"})
+		return
+	}
+
+	invites[invite.ID] = invite
+
+	c.JSON(http.StatusCreated, gin.H{
+		"invite": invite,
+		"token":  tokenString,
+		"link":   inviteLink(screening.ID, tokenString),
+	})
+}
+
+// Sign the token carried by an invite link
+func signInvite(invite *Invite) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti":  invite.ID,
+		"kind": "invite",
+		"iat":  invite.CreatedAt.Unix(),
+		"exp":  invite.ExpiresAt.Unix(),
+	})
+	return token.SignedString([]byte(config.JWTSecret))
+}
+
+// Build the path an invite link opens
+func inviteLink(screeningID, token string) string {
+	return "/?screening=" + url.QueryEscape(screeningID) + "&invite=" + url.QueryEscape(token)
+}
+
+// List invites that haven't expired
+func listInvites(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	result := []*Invite{}
+	for id, invite := range invites {
+		if now.After(invite.ExpiresAt) {
+			delete(invites, id)
+			continue
+		}
+		result = append(result, invite)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": result})
+}
+
+// Revoke an invite link
+func deleteInvite(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := invites[c.Param("id")]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+	delete(invites, c.Param("id"))
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Encode strings, byte slices, integers, lists and string-keyed dictionaries
+// as bencode, with dictionary keys sorted as the format requires
+func bencode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeBencode(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeBencode(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		buf.WriteString(strconv.Itoa(len(v)) + ":" + v)
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(v)) + ":")
+		buf.Write(v)
+	case int:
+		buf.WriteString("i" + strconv.Itoa(v) + "e")
+	case int64:
+		buf.WriteString("i" + strconv.FormatInt(v, 10) + "e")
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, item := range v {
+			if err := writeBencode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('d')
+		for _, key := range keys {
+			writeBencode(buf, key)
+			if err := writeBencode(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("cannot bencode %T", value)
+	}
+	return nil
+}
+
+// Nesting deeper than this is never seen in real torrents
+const maxBencodeDepth = 32
+
+type bdecoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// Decode a bencoded dictionary, also returning each value's raw encoding so
+// a .torrent's info dictionary can be hashed exactly as it was written.
+// Strings come back as string, integers as int64, lists as []interface{}
+// and dictionaries as map[string]interface{}.
+func bdecodeDict(data []byte) (map[string]interface{}, map[string][]byte, error) {
+	d := &bdecoder{data: data}
+	if d.peek() != 'd' {
+		return nil, nil, errors.New("not a bencoded dictionary")
+	}
+	d.pos++
+	d.depth++
+
+	dict := make(map[string]interface{})
+	raw := make(map[string][]byte)
+	for d.peek() != 'e' {
+		key, err := d.decodeString()
+		if err != nil {
+			return nil, nil, err
+		}
+		start := d.pos
+		value, err := d.decode()
+		if err != nil {
+			return nil, nil, err
+		}
+		dict[key] = value
+		raw[key] = data[start:d.pos]
+	}
+	d.pos++
+
+	if d.pos != len(data) {
+		return nil, nil, errors.New("trailing data after bencoded value")
+	}
+	return dict, raw, nil
+}
+
+// Next byte, or 0 at the end of the data
+func (d *bdecoder) peek() byte {
+	if d.pos >= len(d.data) {
+		return 0
+	}
+	return d.data[d.pos]
+}
+
+func (d *bdecoder) decode() (interface{}, error) {
+	switch c := d.peek(); {
+	case c == 'i':
+		return d.decodeInt()
+	case c >= '0' && c <= '9':
+		return d.decodeString()
+	case c == 'l' || c == 'd':
+		if d.depth >= maxBencodeDepth {
+			return nil, errors.New("bencoded value nested too deeply")
+		}
+		d.pos++
+		d.depth++
+		defer func() { d.depth-- }()
+
+		if c == 'l' {
+			list := []interface{}{}
+			for d.peek() != 'e' {
+				item, err := d.decode()
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			d.pos++
+			return list, nil
+		}
+
+		dict := make(map[string]interface{})
+		for d.peek() != 'e' {
+			key, err := d.decodeString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = value
+		}
+		d.pos++
+		return dict, nil
+	case c == 0:
+		return nil, errors.New("unexpected end of bencoded data")
+	default:
+		return nil, fmt.Errorf("invalid bencode at byte %d", d.pos)
+	}
+}
+
+func (d *bdecoder) decodeInt() (int64, error) {
+	end := bytes.IndexByte(d.data[d.pos:], 'e')
+	if end < 0 {
+		return 0, errors.New("unterminated bencoded integer")
+	}
+	value, err := strconv.ParseInt(string(d.data[d.pos+1:d.pos+end]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bencoded integer at byte %d", d.pos)
+	}
+	d.pos += end + 1
+	return value, nil
+}
+
+func (d *bdecoder) decodeString() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", errors.New("unterminated bencoded string length")
+	}
+	length, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("invalid bencoded string length at byte %d", d.pos)
+	}
+	start := d.pos + colon + 1
+	if length > len(d.data)-start {
+		return "", errors.New("bencoded string runs past the end of the data")
+	}
+	d.pos = start + length
+	return string(d.data[start:d.pos]), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBencodeRoundTrip(t *testing.T) {
+	value := map[string]interface{}{
+		"name":   "film",
+		"length": int64(42),
+		"pieces": []byte("\x00\x01binary\xff"),
+		"files": []interface{}{
+			map[string]interface{}{"length": int64(1), "path": []interface{}{"a", "b.mp4"}},
+		},
+		"empty": []interface{}{},
+	}
+
+	encoded, err := bencode(value)
+	if err != nil {
+		t.Fatalf("bencode() error: %v", err)
+	}
+	decoded, raw, err := bdecodeDict(encoded)
+	if err != nil {
+		t.Fatalf("bdecodeDict() error: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"name":   "film",
+		"length": int64(42),
+		"pieces": "\x00\x01binary\xff",
+		"files": []interface{}{
+			map[string]interface{}{"length": int64(1), "path": []interface{}{"a", "b.mp4"}},
+		},
+		"empty": []interface{}{},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("bdecodeDict() = %#v, want %#v", decoded, want)
+	}
+	if string(raw["files"]) != "ld6:lengthi1e4:pathl1:a5:b.mp4eee" {
+		t.Errorf("raw files = %q", raw["files"])
+	}
+}
+
+func TestBencodeKeysSorted(t *testing.T) {
+	encoded, err := bencode(map[string]interface{}{"b": 1, "a": 2, "c": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != "d1:ai2e1:bi1e1:c1:xe" {
+		t.Errorf("bencode() = %q", encoded)
+	}
+}
+
+func TestBdecodeDictErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"not a dictionary", "li1ee"},
+		{"unterminated dictionary", "d1:ai1e"},
+		{"trailing data", "d1:ai1eexyz"},
+		{"string past the end", "d1:a10:shorte"},
+		{"huge string length", "d1:a99999999999999999999:xe"},
+		{"negative string length", "d1:a-1:xe"},
+		{"unterminated integer", "d1:ai12"},
+		{"invalid integer", "d1:ai1x2ee"},
+		{"non-string key", "di1ei2ee"},
+		{"nested too deeply", "d1:a" + strings.Repeat("l", maxBencodeDepth) + strings.Repeat("e", maxBencodeDepth) + "e"},
+		{"invalid byte", "d1:axe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := bdecodeDict([]byte(tt.data)); err == nil {
+				t.Errorf("bdecodeDict(%q) succeeded", tt.data)
+			}
+		})
+	}
+
+	// Just inside the depth limit still decodes
+	depth := maxBencodeDepth - 1
+	data := "d1:a" + strings.Repeat("l", depth) + strings.Repeat("e", depth) + "e"
+	if _, _, err := bdecodeDict([]byte(data)); err != nil {
+		t.Errorf("bdecodeDict() at depth %d: %v", depth, err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"math/bits"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Challenge represents a proof-of-work challenge issued before a visitor token.
+// The client must find a solution such that SHA-256(nonce + solution) starts
+// with Difficulty zero bits.
+type Challenge struct {
+	ID         string    `json:"challenge_id"`
+	Nonce      string    `json:"nonce"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+var (
+	challenges = make(map[string]*Challenge)
+	joinTimes  []time.Time // Visitor creations within the last minute
+)
+
+// Record a visitor creation for the join rate. Must be called with mu held.
+func recordJoin(now time.Time) {
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(joinTimes) && joinTimes[i].Before(cutoff) {
+		i++
+	}
+	joinTimes = append(joinTimes[i:], now)
+}
+
+// Current difficulty: the base difficulty plus one bit for every doubling of
+// the join rate over the spike threshold. Must be called with mu held.
+func currentDifficulty(now time.Time) int {
+	cutoff := now.Add(-time.Minute)
+	joins := 0
+	for _, t := range joinTimes {
+		if t.After(cutoff) {
+			joins++
+		}
+	}
+
+	difficulty := config.PowDifficulty
+	if config.PowSpikeThreshold > 0 && joins > config.PowSpikeThreshold {
+		difficulty += 1 + int(math.Log2(float64(joins)/float64(config.PowSpikeThreshold)))
+	}
+	if difficulty > config.PowMaxDifficulty {
+		difficulty = config.PowMaxDifficulty
+	}
+	return difficulty
+}
+
+// Count the leading zero bits of a hash
+func leadingZeroBits(hash []byte) int {
+	count := 0
+	for _, b := range hash {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// Check and consume a challenge solution. Must be called with mu held.
+func verifyChallenge(challengeID, solution string, now time.Time) bool {
+	challenge, exists := challenges[challengeID]
+	if !exists {
+		return false
+	}
+
+	// Each challenge can be used once, whatever the outcome
+	delete(challenges, challengeID)
+	if now.After(challenge.ExpiresAt) {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(challenge.Nonce + solution))
+	return leadingZeroBits(hash[:]) >= challenge.Difficulty
+}
+
+// Drop expired challenges. Must be called with mu held.
+func pruneChallenges(now time.Time) {
+	for id, challenge := range challenges {
+		if now.After(challenge.ExpiresAt) {
+			delete(challenges, id)
+		}
+	}
+}
+
+// Issue a proof-of-work challenge
+func createChallenge(c *gin.Context) {
+	if !config.PowEnabled {
+		c.JSON(http.StatusOK, gin.H{"required": false})
+		return
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate challenge"})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	challenge := &Challenge{
+		ID:         uuid.New().String(),
+		Nonce:      hex.EncodeToString(nonce),
+		Difficulty: currentDifficulty(now),
+		ExpiresAt:  now.Add(config.PowChallengeTTL),
+	}
+	challenges[challenge.ID] = challenge
+
+	c.JSON(http.StatusOK, gin.H{
+		"required":     true,
+		"algorithm":    "sha256",
+		"challenge_id": challenge.ID,
+		"nonce":        challenge.Nonce,
+		"difficulty":   challenge.Difficulty,
+		"expires_at":   challenge.ExpiresAt,
+	})
+}
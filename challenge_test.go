@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCurrentDifficulty(t *testing.T) {
+	saved, savedJoins := config, joinTimes
+	defer func() { config, joinTimes = saved, savedJoins }()
+
+	now := time.Now()
+	tests := []struct {
+		name      string
+		base      int
+		threshold int
+		max       int
+		joins     int
+		stale     int // Joins older than a minute, which don't count
+		want      int
+	}{
+		{"no joins", 16, 10, 24, 0, 0, 16},
+		{"at threshold", 16, 10, 24, 10, 0, 16},
+		{"just over threshold", 16, 10, 24, 11, 0, 17},
+		{"double the threshold", 16, 10, 24, 20, 0, 18},
+		{"four times the threshold", 16, 10, 24, 40, 0, 19},
+		{"capped at max", 16, 10, 18, 400, 0, 18},
+		{"stale joins ignored", 16, 10, 24, 5, 100, 16},
+		{"spike detection off", 16, 0, 24, 400, 0, 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.PowDifficulty = tt.base
+			config.PowSpikeThreshold = tt.threshold
+			config.PowMaxDifficulty = tt.max
+			joinTimes = nil
+			for i := 0; i < tt.stale; i++ {
+				joinTimes = append(joinTimes, now.Add(-2*time.Minute))
+			}
+			for i := 0; i < tt.joins; i++ {
+				joinTimes = append(joinTimes, now.Add(-time.Second))
+			}
+
+			if got := currentDifficulty(now); got != tt.want {
+				t.Errorf("currentDifficulty() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		hash []byte
+		want int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0xff}, 8},
+		{[]byte{0x00, 0x00, 0x10}, 19},
+		{[]byte{0x00, 0x00}, 16},
+	}
+
+	for _, tt := range tests {
+		if got := leadingZeroBits(tt.hash); got != tt.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", tt.hash, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	saved := challenges
+	defer func() { challenges = saved }()
+
+	// Find a solution with at least 8 leading zero bits, and one without
+	const nonce = "0123456789abcdef"
+	var solved, unsolved string
+	for i := 0; solved == "" || unsolved == ""; i++ {
+		candidate := strconv.Itoa(i)
+		hash := sha256.Sum256([]byte(nonce + candidate))
+		if leadingZeroBits(hash[:]) >= 8 {
+			if solved == "" {
+				solved = candidate
+			}
+		} else if unsolved == "" {
+			unsolved = candidate
+		}
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name      string
+		solution  string
+		expiresAt time.Time
+		want      bool
+	}{
+		{"valid solution", solved, now.Add(time.Minute), true},
+		{"too few zero bits", unsolved, now.Add(time.Minute), false},
+		{"expired", solved, now.Add(-time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenges = map[string]*Challenge{
+				"id": {ID: "id", Nonce: nonce, Difficulty: 8, ExpiresAt: tt.expiresAt},
+			}
+			if got := verifyChallenge("id", tt.solution, now); got != tt.want {
+				t.Errorf("verifyChallenge() = %v, want %v", got, tt.want)
+			}
+			if verifyChallenge("id", tt.solution, now) {
+				t.Error("challenge accepted twice")
+			}
+		})
+	}
+}
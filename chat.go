@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ChatMessage represents a chat message sent in a screening
+type ChatMessage struct {
+	ID          int64     `json:"id"`
+	ScreeningID string    `json:"screening_id"`
+	VisitorID   string    `json:"visitor_id"`
+	VisitorName string    `json:"visitor_name"`
+	Text        string    `json:"text"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+var (
+	chatHistory   = make(map[string][]ChatMessage) // Screening ID -> recent messages, oldest first
+	nextChatID    int64
+	errChatEmpty  = fmt.Errorf("message is empty")
+	errChatLength = fmt.Errorf("message is too long")
+)
+
+// Store a chat message in the screening's bounded history and broadcast it. Must be called with mu held.
+func postChatMessage(visitor *Visitor, screeningID, text string) (ChatMessage, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ChatMessage{}, errChatEmpty
+	}
+	if utf8.RuneCountInString(text) > config.ChatMaxLength {
+		return ChatMessage{}, errChatLength
+	}
+	if until := mutedUntil(visitor); time.Now().Before(until) {
+		return ChatMessage{}, fmt.Errorf("you are muted until %s", until.Format(time.Kitchen))
+	}
+	text = filterWords(text)
+
+	nextChatID++
+	message := ChatMessage{
+		ID:          nextChatID,
+		ScreeningID: screeningID,
+		VisitorID:   visitor.ID,
+		VisitorName: visitor.Name,
+		Text:        text,
+		Timestamp:   time.Now(),
+	}
+
+	history := append(chatHistory[screeningID], message)
+	if len(history) > config.ChatHistorySize {
+		history = history[len(history)-config.ChatHistorySize:]
+	}
+	chatHistory[screeningID] = history
+	visitor.LastActive = message.Timestamp
+
+	broadcastToScreening(screeningID, WebSocketMessage{
+		Type: "chat_message",
+		Data: message,
+	})
+
+	return message, nil
+}
+
+// Get chat messages of a screening sent after the given time. Must be called with mu held.
+func chatMessagesSince(screeningID string, since time.Time) []ChatMessage {
+	messages := []ChatMessage{}
+	for _, message := range chatHistory[screeningID] {
+		if message.Timestamp.After(since) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// Get chat messages
+func getChatMessages(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Verify token
+	visitorID, err := verifyToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	screeningID := c.Param("id")
+
+	// If screening doesn't exist, use default
+	if _, exists := screenings[screeningID]; !exists {
+		screeningID = "default"
+	}
+
+	if visitors[visitorID].ScreeningID != screeningID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a visitor of this screening"})
+		return
+	}
+
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since timestamp"})
+			return
+		}
+		since = parsed
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": chatMessagesSince(screeningID, since)})
+}
+
+// Send a chat message
+func sendChatMessage(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Verify token
+	visitorID, err := verifyToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Message string `json:"message" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	screeningID := c.Param("id")
+
+	// If screening doesn't exist, use default
+	if _, exists := screenings[screeningID]; !exists {
+		screeningID = "default"
+	}
+
+	visitor := visitors[visitorID]
+	if visitor.ScreeningID != screeningID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a visitor of this screening"})
+		return
+	}
+
+	message, err := postChatMessage(visitor, screeningID, request.Message)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"message_id": message.ID,
+	})
+}
+
+// Handle a chat message sent over WebSocket. Must be called with mu held.
+func handleChat(conn *websocket.Conn, visitor *Visitor, data interface{}) {
+	var request struct {
+		Text string `json:"text"`
+	}
+	if err := decodeMessageData(data, &request); err != nil {
+		sendError(conn, "Invalid chat data")
+		return
+	}
+
+	if _, err := postChatMessage(visitor, visitor.ScreeningID, request.Text); err != nil {
+		sendError(conn, err.Error())
+	}
+}
+
+// Send the recent chat history of a screening to a single connection. Must be called with mu held.
+func sendChatHistory(conn *websocket.Conn, screeningID string) {
+	sendJSON(conn, WebSocketMessage{
+		Type: "chat_history",
+		Data: gin.H{"messages": chatMessagesSince(screeningID, time.Time{})},
+	})
+}
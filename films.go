@@ -0,0 +1,271 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Film represents an entry in the instance's film catalog
+type Film struct {
+	ID              string     `json:"id"`
+	Title           string     `json:"title"`
+	Description     string     `json:"description,omitempty"`
+	ReleaseYear     int        `json:"release_year,omitempty"`
+	DurationMinutes int        `json:"duration_minutes"`
+	MagnetLink      string     `json:"magnet_link"`
+	PosterURL       string     `json:"poster_url,omitempty"`
+	Genre           string     `json:"genre,omitempty"`
+	Director        string     `json:"director,omitempty"`
+	IsPublicDomain  bool       `json:"is_public_domain"`
+	MediaPath       string     `json:"media_path,omitempty"` // File or directory in the media library, seeded over HTTP
+	InfoHash        string     `json:"info_hash"`            // Hex BitTorrent v1 info hash
+	Files           []FilmFile `json:"files,omitempty"`
+	TorrentFile     string     `json:"torrent_file,omitempty"` // .torrent served from the torrent directory, when there is one
+	AddedBy         string     `json:"added_by"`
+	AddedAt         time.Time  `json:"added_at"`
+}
+
+// FilmRequest is the body for adding or updating a film
+type FilmRequest struct {
+	Title           string `json:"title" binding:"required"`
+	Description     string `json:"description"`
+	ReleaseYear     int    `json:"release_year"`
+	DurationMinutes int    `json:"duration_minutes" binding:"required,min=1"`
+	MagnetLink      string `json:"magnet_link"`
+	Torrent         []byte `json:"torrent"` // Base64 .torrent file, used instead of or alongside the magnet link
+	PosterURL       string `json:"poster_url"`
+	Genre           string `json:"genre"`
+	Director        string `json:"director"`
+	IsPublicDomain  *bool  `json:"is_public_domain"`
+	MediaPath       string `json:"media_path"`
+}
+
+var films = make(map[string]*Film)
+
+// Parse the request's magnet link or .torrent file. A magnet link sent with
+// a .torrent must be for the same torrent and adds its trackers and sources.
+func (r FilmRequest) content() (*Magnet, []FilmFile, string) {
+	var linked *Magnet
+	if r.MagnetLink != "" {
+		var err error
+		if linked, err = parseMagnet(r.MagnetLink); err != nil {
+			return nil, nil, "Invalid magnet link: " + err.Error()
+		}
+	}
+
+	if len(r.Torrent) == 0 {
+		if linked == nil {
+			return nil, nil, "A magnet link or .torrent file is required"
+		}
+		return linked, nil, ""
+	}
+
+	magnet, files, err := parseTorrent(r.Torrent)
+	if err != nil {
+		return nil, nil, "Invalid .torrent file: " + err.Error()
+	}
+	if linked != nil {
+		if linked.InfoHash != magnet.InfoHash {
+			return nil, nil, "The magnet link and .torrent file are for different torrents"
+		}
+		for _, tracker := range linked.Trackers {
+			magnet.Trackers = appendUnique(magnet.Trackers, tracker)
+		}
+		for _, seed := range linked.WebSeeds {
+			magnet.WebSeeds = appendUnique(magnet.WebSeeds, seed)
+		}
+		magnet.ExactSources = linked.ExactSources
+	}
+	return magnet, files, ""
+}
+
+// Copy a request's fields onto a film, with its magnet link in canonical form
+func (r FilmRequest) apply(film *Film, magnet *Magnet, files []FilmFile) {
+	film.Title = r.Title
+	film.Description = r.Description
+	film.ReleaseYear = r.ReleaseYear
+	film.DurationMinutes = r.DurationMinutes
+	film.MagnetLink = magnet.String()
+	if film.InfoHash != magnet.InfoHash {
+		film.Files = nil
+		film.TorrentFile = ""
+	}
+	film.InfoHash = magnet.InfoHash
+	if files != nil {
+		film.Files = files
+	}
+	film.PosterURL = r.PosterURL
+	film.Genre = r.Genre
+	film.Director = r.Director
+	film.IsPublicDomain = r.IsPublicDomain == nil || *r.IsPublicDomain
+	film.MediaPath = r.MediaPath
+}
+
+// Length of a film's showing; films found by the library scanner may not know theirs yet
+func (f *Film) duration() time.Duration {
+	if f.DurationMinutes <= 0 {
+		return 2 * time.Hour
+	}
+	return time.Duration(f.DurationMinutes) * time.Minute
+}
+
+// List the catalog, newest first
+func listFilms(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	year, _ := strconv.Atoi(c.Query("year"))
+	genre := c.Query("genre")
+
+	result := []*Film{}
+	for _, film := range films {
+		if (genre != "" && film.Genre != genre) || (year != 0 && film.ReleaseYear != year) {
+			continue
+		}
+		result = append(result, film)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].AddedAt.After(result[j].AddedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"total": len(result), "films": result})
+}
+
+// Get a film
+func getFilm(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	film, exists := films[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Film not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, film)
+}
+
+// Add a film to the catalog
+func createFilm(c *gin.Context) {
+	var request FilmRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if message := checkMediaPath(request.MediaPath); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	magnet, files, message := request.content()
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	film := &Film{
+		ID:      uuid.New().String(),
+		AddedBy: currentOperator(c).Name,
+		AddedAt: time.Now(),
+	}
+	if !storeFilmContent(c, film, request, magnet, files) {
+		return
+	}
+	films[film.ID] = film
+
+	c.JSON(http.StatusCreated, film)
+}
+
+// Update a film. Screenings already scheduled keep their copy of the details.
+func updateFilm(c *gin.Context) {
+	var request FilmRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if message := checkMediaPath(request.MediaPath); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	magnet, files, message := request.content()
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	film, exists := films[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Film not found"})
+		return
+	}
+	magnetLink, mediaPath := film.MagnetLink, film.MediaPath
+	if !storeFilmContent(c, film, request, magnet, files) {
+		return
+	}
+
+	// A library film whose source the operator changed is theirs from now on
+	if film.MagnetLink != magnetLink || film.MediaPath != mediaPath {
+		detachLibraryFilm(film.ID)
+	}
+
+	c.JSON(http.StatusOK, film)
+}
+
+// Apply a film request after checking no other film has the same torrent,
+// saving an uploaded .torrent. Responds and returns false on failure. Must be
+// called with mu held.
+func storeFilmContent(c *gin.Context, film *Film, request FilmRequest, magnet *Magnet, files []FilmFile) bool {
+	if existing := filmByInfoHash(magnet.InfoHash); existing != nil && existing != film {
+		c.JSON(http.StatusConflict, gin.H{"error": "A film with this info hash already exists", "film_id": existing.ID})
+		return false
+	}
+
+	torrentFile := ""
+	if len(request.Torrent) > 0 {
+		var err error
+		if torrentFile, err = saveTorrentFile(magnet.InfoHash, request.Torrent); err != nil {
+			log.Printf("Error saving .torrent for %s: %v", magnet.InfoHash, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save the .torrent file"})
+			return false
+		}
+	}
+
+	request.apply(film, magnet, files)
+	if torrentFile != "" {
+		film.TorrentFile = torrentFile
+	}
+	return true
+}
+
+// Remove a film from the catalog. A library film stays deleted until its
+// files leave the media directory.
+func deleteFilm(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := films[c.Param("id")]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Film not found"})
+		return
+	}
+	delete(films, c.Param("id"))
+	delete(subtitles, c.Param("id"))
+	detachLibraryFilm(c.Param("id"))
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/rand"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SeatHold represents a seat reserved for a short time, either for a visitor
+// who is still choosing or for the members of a party
+type SeatHold struct {
+	Row       int       `json:"row"`
+	Seat      int       `json:"seat"`
+	ExpiresAt time.Time `json:"expires_at"`
+	VisitorID string    `json:"-"` // Set for a visitor's own hold
+	PartyCode string    `json:"-"` // Set for a party's block; never sent to clients
+	RSVPCode  string    `json:"-"` // Set for a seat reserved by an RSVP
+}
+
+// Party represents friends sitting together in a reserved block of seats
+type Party struct {
+	Code        string
+	ScreeningID string
+	LeaderID    string
+	Seats       []SeatPosition // The block held for the party, leader's seat first
+	ExpiresAt   time.Time
+}
+
+// Parties by invite code
+var parties = make(map[string]*Party)
+
+// Party invite codes avoid easily confused characters
+const partyCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Generate a party invite code
+func newPartyCode() (string, error) {
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	var code strings.Builder
+	for _, b := range random {
+		code.WriteByte(partyCodeAlphabet[int(b)%len(partyCodeAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// Check whether a hold lets a visitor take the seat
+func (h *SeatHold) heldFor(visitor *Visitor) bool {
+	if h.PartyCode != "" {
+		return h.PartyCode == visitor.PartyCode
+	}
+	if h.RSVPCode != "" {
+		return h.RSVPCode == visitor.RSVPCode
+	}
+	return h.VisitorID == visitor.ID
+}
+
+// Get the hold on a seat, or nil if it isn't held
+func (s *Seats) hold(row, position int) *SeatHold {
+	for i := range s.Held {
+		if s.Held[i].Row == row && s.Held[i].Seat == position {
+			return &s.Held[i]
+		}
+	}
+	return nil
+}
+
+// Keep only the holds for which keep returns true, reporting whether any were dropped
+func (s *Seats) filterHolds(keep func(SeatHold) bool) bool {
+	held := s.Held[:0]
+	for _, hold := range s.Held {
+		if keep(hold) {
+			held = append(held, hold)
+		}
+	}
+	changed := len(held) != len(s.Held)
+	s.Held = held
+	return changed
+}
+
+// Drop expired holds, reporting whether any were dropped
+func (s *Seats) pruneHolds(now time.Time) bool {
+	return s.filterHolds(func(hold SeatHold) bool {
+		return now.Before(hold.ExpiresAt)
+	})
+}
+
+// Drop the hold on a seat
+func (s *Seats) removeHold(row, position int) {
+	s.filterHolds(func(hold SeatHold) bool {
+		return hold.Row != row || hold.Seat != position
+	})
+}
+
+// Drop a visitor's own hold, reporting whether they had one
+func (s *Seats) removeVisitorHolds(visitorID string) bool {
+	return s.filterHolds(func(hold SeatHold) bool {
+		return hold.VisitorID != visitorID
+	})
+}
+
+// Check whether a seat can be given to anyone right now. Must be called with mu held.
+func (s *Seats) free(row, position int) bool {
+	if !s.Layout.selectable(row, position) || s.hold(row, position) != nil {
+		return false
+	}
+	for _, seat := range s.Occupied {
+		if seat.Row == row && seat.Seat == position {
+			return false
+		}
+	}
+	return true
+}
+
+// Find the block of count adjacent free seats nearest the middle of a row,
+// trying the preferred row first and then the rest front to back. Only
+// standard seats are used so wheelchair spaces stay available.
+// Must be called with mu held.
+func (s *Seats) findAdjacent(count, preferredRow int) []SeatPosition {
+	rows := make([]int, 0, len(s.Layout.Rows))
+	if preferredRow >= 0 && preferredRow < len(s.Layout.Rows) {
+		rows = append(rows, preferredRow)
+	}
+	for row := range s.Layout.Rows {
+		if row != preferredRow {
+			rows = append(rows, row)
+		}
+	}
+
+	for _, row := range rows {
+		seats := s.Layout.Rows[row].Seats
+		middle := float64(len(seats)-1) / 2
+		best, bestDistance := -1, math.Inf(1)
+
+		run := 0
+		for position := range seats {
+			if seats[position].Type != SeatStandard || !s.free(row, position) {
+				run = 0
+				continue
+			}
+			run++
+			if run >= count {
+				start := position - count + 1
+				distance := math.Abs(float64(start+position)/2 - middle)
+				if distance < bestDistance {
+					best, bestDistance = start, distance
+				}
+			}
+		}
+
+		if best >= 0 {
+			block := make([]SeatPosition, count)
+			for i := range block {
+				block[i] = SeatPosition{Row: row, Seat: best + i}
+			}
+			return block
+		}
+	}
+	return nil
+}
+
+// Hold a seat for a short time while the visitor decides
+func holdSeat(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Verify token
+	visitorID, err := verifyToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		RowNumber  *int `json:"row_number" binding:"required"`
+		SeatNumber *int `json:"seat_number" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	screeningID, screening := screeningParam(c)
+	visitor := visitors[visitorID]
+
+	if status, message := checkSeat(screening, *request.RowNumber, *request.SeatNumber, visitor); message != "" {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	// A visitor holds at most one seat at a time
+	screening.Seats.removeVisitorHolds(visitorID)
+
+	// Party seats are already held for the visitor
+	hold := screening.Seats.hold(*request.RowNumber, *request.SeatNumber)
+	if hold == nil {
+		screening.Seats.Held = append(screening.Seats.Held, SeatHold{
+			Row:       *request.RowNumber,
+			Seat:      *request.SeatNumber,
+			ExpiresAt: time.Now().Add(config.SeatHoldTTL),
+			VisitorID: visitorID,
+		})
+		hold = &screening.Seats.Held[len(screening.Seats.Held)-1]
+	}
+	visitor.LastActive = time.Now()
+
+	broadcastSeats(screeningID, screening)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"hold":    *hold,
+	})
+}
+
+// Release the visitor's own seat hold
+func releaseSeatHold(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Verify token
+	visitorID, err := verifyToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	screeningID, screening := screeningParam(c)
+	if screening.Seats.removeVisitorHolds(visitorID) {
+		broadcastSeats(screeningID, screening)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Reserve a block of adjacent seats for a party, seating the leader in the first one
+func reserveGroupSeats(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Verify token
+	visitorID, err := verifyToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Count        int  `json:"count" binding:"required"`
+		PreferredRow *int `json:"row_number"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil || request.Count < 2 || request.Count > config.MaxPartySize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Party size must be between 2 and " + strconv.Itoa(config.MaxPartySize)})
+		return
+	}
+
+	screeningID, screening := screeningParam(c)
+	visitor := visitors[visitorID]
+	now := time.Now()
+
+	if visitor.ScreeningID != screeningID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not in this lobby"})
+		return
+	}
+
+	preferredRow := -1
+	if request.PreferredRow != nil {
+		preferredRow = *request.PreferredRow
+	}
+
+	screening.Seats.pruneHolds(now)
+	block := screening.Seats.findAdjacent(request.Count, preferredRow)
+	if block == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "No block of adjacent seats available"})
+		return
+	}
+	for _, seat := range block {
+		if status, message := checkSeat(screening, seat.Row, seat.Seat, visitor); status != http.StatusOK {
+			c.JSON(status, gin.H{"error": message})
+			return
+		}
+	}
+
+	// Each seat passes on its own, so check the block as a whole doesn't use
+	// seats kept for RSVPs. A seated leader frees their old seat.
+	needed := request.Count
+	if visitor.Seat != nil {
+		needed--
+	}
+	if rsvpSpareSeats(screening) < needed {
+		c.JSON(http.StatusConflict, gin.H{"error": "The remaining seats are reserved for RSVPs"})
+		return
+	}
+
+	code, err := newPartyCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create party"})
+		return
+	}
+
+	party := &Party{
+		Code:        code,
+		ScreeningID: screeningID,
+		LeaderID:    visitorID,
+		Seats:       block,
+		ExpiresAt:   now.Add(config.PartyHoldTTL),
+	}
+	parties[code] = party
+	visitor.PartyCode = code
+
+	// Hold the whole block in one step, then seat the leader in it
+	screening.Seats.removeVisitorHolds(visitorID)
+	for _, seat := range block {
+		screening.Seats.Held = append(screening.Seats.Held, SeatHold{
+			Row:       seat.Row,
+			Seat:      seat.Seat,
+			ExpiresAt: party.ExpiresAt,
+			PartyCode: code,
+		})
+	}
+	leaderSeat := assignSeat(screening, visitor, block[0].Row, block[0].Seat)
+
+	broadcastSeats(screeningID, screening)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"party_code": code,
+		"seat":       leaderSeat,
+		"block":      block,
+		"expires_at": party.ExpiresAt,
+	})
+}
+
+// Join a party and take the next free seat in its block
+func joinParty(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Verify token
+	visitorID, err := verifyToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	screeningID, screening := screeningParam(c)
+	now := time.Now()
+
+	party, exists := parties[strings.ToUpper(c.Param("code"))]
+	if !exists || party.ScreeningID != screeningID || now.After(party.ExpiresAt) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Party not found"})
+		return
+	}
+
+	visitor := visitors[visitorID]
+	visitor.PartyCode = party.Code
+
+	// Members already seated in the block keep their seat
+	if visitor.Seat != nil {
+		for _, seat := range party.Seats {
+			if seat.Row == visitor.Seat.Row && seat.Seat == visitor.Seat.Seat {
+				c.JSON(http.StatusOK, gin.H{"success": true, "seat": visitor.Seat})
+				return
+			}
+		}
+	}
+
+	screening.Seats.pruneHolds(now)
+	for _, position := range party.Seats {
+		if hold := screening.Seats.hold(position.Row, position.Seat); hold == nil || hold.PartyCode != party.Code {
+			continue
+		}
+		if status, _ := checkSeat(screening, position.Row, position.Seat, visitor); status != http.StatusOK {
+			continue
+		}
+
+		seat := assignSeat(screening, visitor, position.Row, position.Seat)
+		broadcastSeats(screeningID, screening)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"seat":    seat,
+		})
+		return
+	}
+
+	c.JSON(http.StatusConflict, gin.H{"error": "The party's seats are all taken"})
+}
+
+// Expire seat holds and parties, telling visitors about freed seats
+func expireSeatHolds() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		mu.Lock()
+		for screeningID, screening := range screenings {
+			if screening.Seats.pruneHolds(now) {
+				broadcastSeats(screeningID, screening)
+			}
+		}
+		for code, party := range parties {
+			if now.After(party.ExpiresAt) {
+				delete(parties, code)
+			}
+		}
+		mu.Unlock()
+	}
+}
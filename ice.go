@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ICEServer is an RTCIceServer entry for the browser's RTCPeerConnection
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// TURN credentials in the TURN REST API scheme coturn checks with
+// use-auth-secret: the username is "<expiry unix time>:<user>" and the
+// password is base64(HMAC-SHA1(secret, username))
+func turnCredentials(user string, expiresAt time.Time) (string, string) {
+	username := strconv.FormatInt(expiresAt.Unix(), 10) + ":" + user
+	mac := hmac.New(sha1.New, []byte(config.TURNSecret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Build the ICE servers for a visitor, with the embedded STUN server on
+// host first once it is listening. TURN credentials never outlive the
+// visitor's token. Must be called with mu held.
+func iceServers(host, visitorID string, tokenExpiry time.Time, now time.Time) ([]ICEServer, time.Time) {
+	servers := []ICEServer{}
+
+	var stun []string
+	if stunListening && host != "" {
+		stun = append(stun, "stun:"+net.JoinHostPort(host, config.STUNPort))
+	}
+	for _, server := range config.STUNServers {
+		if server = strings.TrimSpace(server); server != "" {
+			stun = append(stun, server)
+		}
+	}
+	if len(stun) > 0 {
+		servers = append(servers, ICEServer{URLs: stun})
+	}
+
+	expiresAt := now.Add(config.TURNCredentialTTL)
+	if !tokenExpiry.IsZero() && tokenExpiry.Before(expiresAt) {
+		expiresAt = tokenExpiry
+	}
+
+	var turn []string
+	for _, server := range config.TURNServers {
+		if server = strings.TrimSpace(server); server != "" {
+			turn = append(turn, server)
+		}
+	}
+	if len(turn) > 0 && config.TURNSecret != "" {
+		username, credential := turnCredentials(visitorID, expiresAt)
+		servers = append(servers, ICEServer{URLs: turn, Username: username, Credential: credential})
+	}
+
+	return servers, expiresAt
+}
+
+// Get the ICE servers for the visitor's peer connections
+func getICEServers(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	claims, err := parseVisitorClaims(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var tokenExpiry time.Time
+	if expiry, err := claims.GetExpirationTime(); err == nil && expiry != nil {
+		tokenExpiry = expiry.Time
+	}
+
+	now := time.Now()
+	var host string
+	if parsed, err := url.Parse(publicURL(c)); err == nil {
+		host = parsed.Hostname()
+	}
+	servers, expiresAt := iceServers(host, claims["sub"].(string), tokenExpiry, now)
+
+	c.JSON(http.StatusOK, gin.H{
+		"ice_servers": servers,
+		"expires_at":  expiresAt,
+		"ttl":         int(expiresAt.Sub(now).Seconds()),
+	})
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Video files the scanner picks up at the top of the media directory.
+// Directories are taken whole, so subtitles and extras ride along.
+var videoExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".webm": true, ".mkv": true, ".ogv": true, ".mov": true,
+}
+
+// LibraryEntry is the scanner's record of one film in the media library
+type LibraryEntry struct {
+	Path      string        `json:"path"` // File or directory name in the media directory
+	Files     []LibraryFile `json:"files"`
+	InfoHash  string        `json:"info_hash"`
+	Torrent   string        `json:"torrent"` // .torrent file name in the torrent directory
+	FilmID    string        `json:"film_id"`
+	Detached  bool          `json:"detached,omitempty"` // The operator deleted or edited the film; the scanner leaves it alone
+	ScannedAt time.Time     `json:"scanned_at"`
+}
+
+// LibraryFile is one file of an entry, with what the scanner saw last time
+type LibraryFile struct {
+	Path    string    `json:"path"` // Slash-separated, relative to the entry; empty for a single file
+	Length  int64     `json:"length"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// LibraryScan summarizes one pass of the scanner
+type LibraryScan struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Added      int       `json:"added"`
+	Updated    int       `json:"updated"`
+	Unchanged  int       `json:"unchanged"`
+	Detached   int       `json:"detached"`
+	Removed    int       `json:"removed"`
+	Errors     []string  `json:"errors"`
+}
+
+var errScanRunning = errors.New("a library scan is already running")
+
+var (
+	library         = make(map[string]*LibraryEntry) // Path -> entry
+	libraryScanning bool
+	lastLibraryScan *LibraryScan
+)
+
+// Whether two listings of an entry's files match
+func sameFiles(a, b []LibraryFile) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Path != b[i].Path || a[i].Length != b[i].Length || !a[i].ModTime.Equal(b[i].ModTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// List the files making up a library entry, sorted by path. Top-level files
+// that aren't videos give an empty list.
+func listLibraryFiles(name string, isDir bool) ([]LibraryFile, error) {
+	root := filepath.Join(config.MediaDir, name)
+	if !isDir {
+		if !videoExtensions[strings.ToLower(filepath.Ext(name))] {
+			return nil, nil
+		}
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		return []LibraryFile{{Length: info.Size(), ModTime: info.ModTime()}}, nil
+	}
+
+	var files []LibraryFile
+	err := filepath.WalkDir(root, func(full string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && full != root {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relative, _ := filepath.Rel(root, full)
+		files = append(files, LibraryFile{
+			Path:    filepath.ToSlash(relative),
+			Length:  info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, err
+}
+
+// Pick a power-of-two piece length giving at most about 2000 pieces
+func choosePieceLength(total int64) int64 {
+	length := int64(16 * 1024)
+	for length < 16*1024*1024 && total/length > 2000 {
+		length *= 2
+	}
+	return length
+}
+
+// Hash an entry's files as one stream cut into pieces, as BitTorrent v1 does
+func hashPieces(name string, files []LibraryFile, pieceLength int64) ([]byte, error) {
+	var pieces []byte
+	buf := make([]byte, pieceLength)
+	filled := 0
+
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(config.MediaDir, name, filepath.FromSlash(file.Path)))
+		if err != nil {
+			return nil, err
+		}
+		for {
+			n, err := io.ReadFull(f, buf[filled:])
+			filled += n
+			if filled == len(buf) {
+				sum := sha1.Sum(buf)
+				pieces = append(pieces, sum[:]...)
+				filled = 0
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		f.Close()
+	}
+
+	if filled > 0 {
+		sum := sha1.Sum(buf[:filled])
+		pieces = append(pieces, sum[:]...)
+	}
+	return pieces, nil
+}
+
+// Hash an entry, write its .torrent file and return the entry
+func buildTorrent(name string, files []LibraryFile) (*LibraryEntry, error) {
+	var total int64
+	for _, file := range files {
+		total += file.Length
+	}
+	pieceLength := choosePieceLength(total)
+
+	pieces, err := hashPieces(name, files, pieceLength)
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]interface{}{
+		"name":         name,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}
+	if len(files) == 1 && files[0].Path == "" {
+		info["length"] = files[0].Length
+	} else {
+		list := make([]interface{}, 0, len(files))
+		for _, file := range files {
+			components := []interface{}{}
+			for _, component := range strings.Split(file.Path, "/") {
+				components = append(components, component)
+			}
+			list = append(list, map[string]interface{}{"length": file.Length, "path": components})
+		}
+		info["files"] = list
+	}
+
+	encodedInfo, err := bencode(info)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(encodedInfo)
+	infoHash := hex.EncodeToString(sum[:])
+
+	metainfo := map[string]interface{}{
+		"info":          info,
+		"created by":    "Virtuaplex",
+		"creation date": time.Now().Unix(),
+	}
+	if config.PublicURL != "" {
+		metainfo["announce"] = strings.Replace(strings.TrimSuffix(config.PublicURL, "/"), "http", "ws", 1) + "/announce"
+	}
+	torrent, err := bencode(metainfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(config.TorrentDir, 0755); err != nil {
+		return nil, err
+	}
+	torrentName := infoHash + ".torrent"
+	if err := os.WriteFile(filepath.Join(config.TorrentDir, torrentName), torrent, 0644); err != nil {
+		return nil, err
+	}
+
+	return &LibraryEntry{
+		Path:      name,
+		Files:     files,
+		InfoHash:  infoHash,
+		Torrent:   torrentName,
+		ScannedAt: time.Now(),
+	}, nil
+}
+
+// List an entry's files the way its torrent names them
+func (entry *LibraryEntry) filmFiles() []FilmFile {
+	files := make([]FilmFile, len(entry.Files))
+	for i, file := range entry.Files {
+		files[i] = FilmFile{Path: path.Join(entry.Path, file.Path), Length: file.Length}
+	}
+	return files
+}
+
+// Turn a file name into a film title
+func titleFromName(name string) string {
+	title := strings.TrimSuffix(name, filepath.Ext(name))
+	title = strings.NewReplacer(".", " ", "_", " ").Replace(title)
+	return strings.TrimSpace(title)
+}
+
+// Link an entry to the film with its info hash, or the film it was linked to
+// before its files changed, creating one if neither exists. Returns whether a
+// film was created. Must be called with mu held.
+func registerLibraryEntry(entry *LibraryEntry, previous *LibraryEntry) bool {
+	film := filmByInfoHash(entry.InfoHash)
+	if film == nil && previous != nil {
+		film = films[previous.FilmID]
+	}
+
+	created := film == nil
+	if created {
+		film = &Film{
+			ID:             uuid.New().String(),
+			Title:          titleFromName(entry.Path),
+			IsPublicDomain: true,
+			AddedBy:        "library",
+			AddedAt:        time.Now(),
+		}
+		films[film.ID] = film
+	}
+
+	// Web seed and exact source are added per response, from the request's host
+	film.InfoHash = entry.InfoHash
+	film.MagnetLink = (&Magnet{InfoHash: entry.InfoHash, Name: entry.Path}).String()
+	film.Files = entry.filmFiles()
+	film.TorrentFile = entry.Torrent
+	film.MediaPath = entry.Path
+	entry.FilmID = film.ID
+	library[entry.Path] = entry
+	return created
+}
+
+// Stop the scanner from recreating or overwriting a film the operator
+// deleted or edited. The entry stays detached until its files leave the
+// media directory. Must be called with mu held.
+func detachLibraryFilm(filmID string) {
+	detached := false
+	for _, entry := range library {
+		if entry.FilmID == filmID && !entry.Detached {
+			entry.Detached = true
+			detached = true
+		}
+	}
+	if !detached {
+		return
+	}
+	if err := saveLibraryIndex(); err != nil {
+		log.Printf("Failed to save library index: %v", err)
+	}
+}
+
+// Delete a .torrent written by the scanner unless a film still uses it. Must
+// be called with mu held.
+func removeLibraryTorrent(name string) {
+	for _, film := range films {
+		if film.TorrentFile == name {
+			return
+		}
+	}
+	if err := os.Remove(filepath.Join(config.TorrentDir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove %s: %v", name, err)
+	}
+}
+
+// Walk the media directory, hashing new and changed entries and skipping
+// unchanged ones
+func scanLibrary() (*LibraryScan, error) {
+	if config.MediaDir == "" {
+		return nil, errors.New("no media library configured")
+	}
+
+	mu.Lock()
+	if libraryScanning {
+		mu.Unlock()
+		return nil, errScanRunning
+	}
+	libraryScanning = true
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		libraryScanning = false
+		mu.Unlock()
+	}()
+
+	dirEntries, err := os.ReadDir(config.MediaDir)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := &LibraryScan{StartedAt: time.Now(), Errors: []string{}}
+
+	mu.Lock()
+	previous := make(map[string]*LibraryEntry, len(library))
+	for entryPath, entry := range library {
+		previous[entryPath] = entry
+	}
+	mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		files, err := listLibraryFiles(name, dirEntry.IsDir())
+		if err != nil {
+			scan.Errors = append(scan.Errors, name+": "+err.Error())
+			continue
+		}
+		if len(files) == 0 {
+			continue
+		}
+		seen[name] = true
+
+		// Films the operator took over are never touched again
+		old := previous[name]
+		if old != nil && old.Detached {
+			scan.Detached++
+			continue
+		}
+
+		// Unchanged files keep their hashes; only the film link is refreshed
+		if old != nil && sameFiles(old.Files, files) {
+			mu.Lock()
+			registerLibraryEntry(old, old)
+			mu.Unlock()
+			scan.Unchanged++
+			continue
+		}
+
+		entry, err := buildTorrent(name, files)
+		if err != nil {
+			scan.Errors = append(scan.Errors, name+": "+err.Error())
+			continue
+		}
+
+		mu.Lock()
+		if registerLibraryEntry(entry, old) {
+			scan.Added++
+		} else {
+			scan.Updated++
+		}
+		if old != nil && old.Torrent != entry.Torrent {
+			removeLibraryTorrent(old.Torrent)
+		}
+		mu.Unlock()
+	}
+
+	// Films whose files are gone stay in the catalog without a web seed or
+	// the scanner's .torrent
+	mu.Lock()
+	defer mu.Unlock()
+	for entryPath, entry := range library {
+		if seen[entryPath] {
+			continue
+		}
+		if film, exists := films[entry.FilmID]; exists && !entry.Detached {
+			if film.MediaPath == entryPath {
+				film.MediaPath = ""
+			}
+			if film.TorrentFile == entry.Torrent {
+				film.TorrentFile = ""
+			}
+		}
+		delete(library, entryPath)
+		removeLibraryTorrent(entry.Torrent)
+		scan.Removed++
+	}
+
+	scan.FinishedAt = time.Now()
+	lastLibraryScan = scan
+	if err := saveLibraryIndex(); err != nil {
+		log.Printf("Failed to save library index: %v", err)
+	}
+
+	return scan, nil
+}
+
+// Save what the scanner has seen so restarts skip unchanged files. Must be called with mu held.
+func saveLibraryIndex() error {
+	entries := make([]*LibraryEntry, 0, len(library))
+	for _, entry := range library {
+		entries = append(entries, entry)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(config.TorrentDir, "library.json"), data, 0644)
+}
+
+// Load the index saved by an earlier run, if any
+func loadLibraryIndex() {
+	data, err := os.ReadFile(filepath.Join(config.TorrentDir, "library.json"))
+	if err != nil {
+		return
+	}
+
+	var entries []*LibraryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("Ignoring unreadable library index: %v", err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, entry := range entries {
+		library[entry.Path] = entry
+	}
+}
+
+// Scan the library at startup and then periodically
+func runLibraryScanner() {
+	if config.MediaDir == "" {
+		return
+	}
+	loadLibraryIndex()
+
+	for {
+		if scan, err := scanLibrary(); err != nil {
+			log.Printf("Library scan failed: %v", err)
+		} else {
+			log.Printf("Library scan: %d added, %d updated, %d unchanged, %d removed, %d errors",
+				scan.Added, scan.Updated, scan.Unchanged, scan.Removed, len(scan.Errors))
+		}
+
+		if config.LibraryScanInterval <= 0 {
+			return
+		}
+		time.Sleep(config.LibraryScanInterval)
+	}
+}
+
+// Get the library entries and the last scan's results
+func getLibrary(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	entries := make([]*LibraryEntry, 0, len(library))
+	for _, entry := range library {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"media_dir": config.MediaDir,
+		"entries":   entries,
+		"last_scan": lastLibraryScan,
+		"scanning":  libraryScanning,
+	})
+}
+
+// Start a library scan in the background
+func startLibraryScan(c *gin.Context) {
+	if config.MediaDir == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "No media library configured"})
+		return
+	}
+
+	mu.Lock()
+	scanning := libraryScanning
+	mu.Unlock()
+	if scanning {
+		c.JSON(http.StatusConflict, gin.H{"error": "A library scan is already running"})
+		return
+	}
+
+	go func() {
+		if _, err := scanLibrary(); err != nil {
+			log.Printf("Library scan failed: %v", err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{"scanning": true})
+}
+
+// Serve a .torrent file written by the scanner
+func serveTorrentFile(c *gin.Context) {
+	name := path.Base(c.Param("file"))
+	if !strings.HasSuffix(name, ".torrent") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("Content-Type", "application/x-bittorrent")
+	c.File(filepath.Join(config.TorrentDir, name))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestHashPieces(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	tests := []struct {
+		name        string
+		sizes       []int // File sizes; a single file of its own when there's one
+		pieceLength int64
+	}{
+		{"single file, whole pieces", []int{32}, 8},
+		{"single file, short last piece", []int{29}, 8},
+		{"single file smaller than a piece", []int{5}, 8},
+		{"files crossing piece boundaries", []int{10, 7, 5}, 8},
+		{"files ending on piece boundaries", []int{8, 16, 8}, 8},
+		{"empty file in between", []int{6, 0, 6}, 8},
+		{"many small files in one piece", []int{1, 2, 3}, 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.MediaDir = t.TempDir()
+
+			// Fill the files with distinct bytes so misplaced data changes the hashes
+			var stream []byte
+			var files []LibraryFile
+			name := "film.mp4"
+			if len(tt.sizes) > 1 {
+				name = "film"
+				os.Mkdir(filepath.Join(config.MediaDir, name), 0755)
+			}
+			for i, size := range tt.sizes {
+				data := make([]byte, size)
+				for j := range data {
+					data[j] = byte(len(stream) + j)
+				}
+				stream = append(stream, data...)
+
+				file := LibraryFile{Length: int64(size)}
+				if len(tt.sizes) > 1 {
+					file.Path = "part" + strconv.Itoa(i)
+				}
+				if err := os.WriteFile(filepath.Join(config.MediaDir, name, file.Path), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, file)
+			}
+
+			var want []byte
+			for start := 0; start < len(stream); start += int(tt.pieceLength) {
+				end := start + int(tt.pieceLength)
+				if end > len(stream) {
+					end = len(stream)
+				}
+				sum := sha1.Sum(stream[start:end])
+				want = append(want, sum[:]...)
+			}
+
+			got, err := hashPieces(name, files, tt.pieceLength)
+			if err != nil {
+				t.Fatalf("hashPieces() error: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("hashPieces() gave %d pieces not matching the %d expected", len(got)/sha1.Size, len(want)/sha1.Size)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		config.MediaDir = t.TempDir()
+		if _, err := hashPieces("gone.mp4", []LibraryFile{{Length: 4}}, 8); err == nil {
+			t.Error("hashPieces() of a missing file succeeded")
+		}
+	})
+}
+
+func TestChoosePieceLength(t *testing.T) {
+	tests := []struct {
+		total int64
+		want  int64
+	}{
+		{0, 16 * 1024},
+		{16 * 1024 * 2000, 16 * 1024},
+		{16*1024*2000 + 16*1024, 32 * 1024},
+		{1 << 40, 16 * 1024 * 1024},
+	}
+
+	for _, tt := range tests {
+		if got := choosePieceLength(tt.total); got != tt.want {
+			t.Errorf("choosePieceLength(%d) = %d, want %d", tt.total, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Magnet is a parsed magnet link
+type Magnet struct {
+	InfoHash     string   // Lowercase hex BitTorrent v1 info hash
+	Name         string   // dn
+	Trackers     []string // tr
+	WebSeeds     []string // ws
+	ExactSources []string // xs
+}
+
+// FilmFile is one file in a film's torrent
+type FilmFile struct {
+	Path   string `json:"path"` // Slash-separated, starting with the torrent name
+	Length int64  `json:"length"`
+}
+
+// Parse and validate a magnet link. Numbered parameters like tr.1 are
+// treated as their plain form.
+func parseMagnet(link string) (*Magnet, error) {
+	link = strings.TrimSpace(link)
+	if !strings.HasPrefix(strings.ToLower(link), "magnet:?") {
+		return nil, errors.New("must start with magnet:?")
+	}
+	query, err := url.ParseQuery(link[len("magnet:?"):])
+	if err != nil {
+		return nil, errors.New("not a valid query string")
+	}
+
+	// Sorted so tr, tr.1, tr.2... tr.10 keep their order
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sort.SliceStable(keys, func(i, j int) bool {
+		nameI, indexI := magnetKeyOrder(keys[i])
+		nameJ, indexJ := magnetKeyOrder(keys[j])
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+		return indexI < indexJ
+	})
+
+	magnet := &Magnet{}
+	for _, key := range keys {
+		name, _, _ := strings.Cut(strings.ToLower(key), ".")
+		for _, value := range query[key] {
+			switch name {
+			case "xt":
+				if !strings.HasPrefix(strings.ToLower(value), "urn:btih:") {
+					continue
+				}
+				infoHash, err := parseInfoHash(value[len("urn:btih:"):])
+				if err != nil {
+					return nil, err
+				}
+				if magnet.InfoHash != "" && magnet.InfoHash != infoHash {
+					return nil, errors.New("more than one info hash")
+				}
+				magnet.InfoHash = infoHash
+			case "dn":
+				magnet.Name = value
+			case "tr":
+				if !validURL(value, "udp", "http", "https", "ws", "wss") {
+					return nil, fmt.Errorf("invalid tracker %s", value)
+				}
+				magnet.Trackers = appendUnique(magnet.Trackers, value)
+			case "ws", "xs":
+				if !validURL(value, "http", "https") {
+					return nil, fmt.Errorf("invalid %s URL %s", name, value)
+				}
+				if name == "ws" {
+					magnet.WebSeeds = appendUnique(magnet.WebSeeds, value)
+				} else {
+					magnet.ExactSources = appendUnique(magnet.ExactSources, value)
+				}
+			}
+		}
+	}
+
+	if magnet.InfoHash == "" {
+		return nil, errors.New("no BitTorrent info hash (xt=urn:btih:...)")
+	}
+	return magnet, nil
+}
+
+// Split a magnet parameter like tr.2 into its name and number; the plain form
+// comes first and numbers that don't parse come last
+func magnetKeyOrder(key string) (string, int) {
+	name, number, numbered := strings.Cut(strings.ToLower(key), ".")
+	if !numbered {
+		return name, -1
+	}
+	index, err := strconv.Atoi(number)
+	if err != nil || index < 0 {
+		return name, math.MaxInt
+	}
+	return name, index
+}
+
+// Normalize a btih value, given as 40 hex or 32 base32 characters, to lowercase hex
+func parseInfoHash(value string) (string, error) {
+	switch len(value) {
+	case 40:
+		if _, err := hex.DecodeString(value); err == nil {
+			return strings.ToLower(value), nil
+		}
+	case 32:
+		if decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(value)); err == nil {
+			return hex.EncodeToString(decoded), nil
+		}
+	}
+	return "", errors.New("info hash must be 40 hex or 32 base32 characters")
+}
+
+// Whether value is an absolute URL with a host and one of the given schemes
+func validURL(value string, schemes ...string) bool {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(parsed.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// Build the canonical form of a magnet link: info hash, name, the
+// instance's trackers followed by the link's own, then web seeds and exact
+// sources, in a stable order
+func (m *Magnet) String() string {
+	trackers := []string{}
+	for _, tracker := range config.Trackers {
+		if tracker = strings.TrimSpace(tracker); tracker != "" {
+			trackers = appendUnique(trackers, tracker)
+		}
+	}
+	for _, tracker := range m.Trackers {
+		trackers = appendUnique(trackers, tracker)
+	}
+
+	link := "magnet:?xt=urn:btih:" + m.InfoHash
+	if m.Name != "" {
+		link += "&dn=" + url.QueryEscape(m.Name)
+	}
+	for _, tracker := range trackers {
+		link += "&tr=" + url.QueryEscape(tracker)
+	}
+	for _, seed := range m.WebSeeds {
+		link += "&ws=" + url.QueryEscape(seed)
+	}
+	for _, source := range m.ExactSources {
+		link += "&xs=" + url.QueryEscape(source)
+	}
+	return link
+}
+
+// Read a .torrent file into a magnet link and its file list
+func parseTorrent(data []byte) (*Magnet, []FilmFile, error) {
+	torrent, raw, err := bdecodeDict(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, ok := torrent["info"].(map[string]interface{})
+	if !ok {
+		return nil, nil, errors.New("no info dictionary")
+	}
+
+	name, _ := info["name"].(string)
+	if name == "" || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return nil, nil, errors.New("missing or unsafe name")
+	}
+	if pieceLength, _ := info["piece length"].(int64); pieceLength <= 0 {
+		return nil, nil, errors.New("missing piece length")
+	}
+	if pieces, _ := info["pieces"].(string); pieces == "" || len(pieces)%sha1.Size != 0 {
+		return nil, nil, errors.New("missing piece hashes")
+	}
+
+	var files []FilmFile
+	if length, ok := info["length"].(int64); ok {
+		files = []FilmFile{{Path: name, Length: length}}
+	} else {
+		list, _ := info["files"].([]interface{})
+		for _, item := range list {
+			file, _ := item.(map[string]interface{})
+			length, _ := file["length"].(int64)
+			parts, _ := file["path"].([]interface{})
+
+			segments := []string{name}
+			for _, part := range parts {
+				segment, _ := part.(string)
+				if segment == "" || strings.ContainsAny(segment, "/\\") || segment == "." || segment == ".." {
+					return nil, nil, errors.New("unsafe file path")
+				}
+				segments = append(segments, segment)
+			}
+			if len(segments) == 1 || length < 0 {
+				return nil, nil, errors.New("malformed file list")
+			}
+			files = append(files, FilmFile{Path: path.Join(segments...), Length: length})
+		}
+		if len(files) == 0 {
+			return nil, nil, errors.New("no files")
+		}
+	}
+
+	sum := sha1.Sum(raw["info"])
+	magnet := &Magnet{InfoHash: hex.EncodeToString(sum[:]), Name: name}
+
+	// Trackers and web seeds the torrent lists are kept when they're usable
+	if announce, _ := torrent["announce"].(string); validURL(announce, "udp", "http", "https", "ws", "wss") {
+		magnet.Trackers = appendUnique(magnet.Trackers, announce)
+	}
+	tiers, _ := torrent["announce-list"].([]interface{})
+	for _, tier := range tiers {
+		urls, _ := tier.([]interface{})
+		for _, item := range urls {
+			if tracker, _ := item.(string); validURL(tracker, "udp", "http", "https", "ws", "wss") {
+				magnet.Trackers = appendUnique(magnet.Trackers, tracker)
+			}
+		}
+	}
+	seeds := torrent["url-list"]
+	if seed, ok := seeds.(string); ok {
+		seeds = []interface{}{seed}
+	}
+	list, _ := seeds.([]interface{})
+	for _, item := range list {
+		if seed, _ := item.(string); validURL(seed, "http", "https") {
+			magnet.WebSeeds = appendUnique(magnet.WebSeeds, seed)
+		}
+	}
+
+	return magnet, files, nil
+}
+
+// Store an uploaded .torrent next to the scanner's so it can be served as
+// an exact source. Returns the file name in the torrent directory.
+func saveTorrentFile(infoHash string, data []byte) (string, error) {
+	if err := os.MkdirAll(config.TorrentDir, 0755); err != nil {
+		return "", err
+	}
+	name := infoHash + ".torrent"
+	if err := os.WriteFile(filepath.Join(config.TorrentDir, name), data, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Find the film with an info hash. Must be called with mu held.
+func filmByInfoHash(infoHash string) *Film {
+	for _, film := range films {
+		if film.InfoHash == infoHash {
+			return film
+		}
+	}
+	return nil
+}
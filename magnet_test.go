@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	const hash = "dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c"
+	raw, _ := hex.DecodeString(hash)
+	base32Hash := base32.StdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name    string
+		link    string
+		want    *Magnet
+		wantErr bool
+	}{
+		{
+			name: "hex info hash",
+			link: "magnet:?xt=urn:btih:" + hash + "&dn=Big+Buck+Bunny",
+			want: &Magnet{InfoHash: hash, Name: "Big Buck Bunny"},
+		},
+		{
+			name: "uppercase hex normalized",
+			link: "magnet:?xt=urn:btih:DD8255ECDC7CA55FB0BBF81323D87062DB1F6D1C",
+			want: &Magnet{InfoHash: hash},
+		},
+		{
+			name: "base32 info hash",
+			link: "magnet:?xt=urn:btih:" + base32Hash,
+			want: &Magnet{InfoHash: hash},
+		},
+		{
+			name: "lowercase base32 info hash",
+			link: "MAGNET:?xt=urn:btih:" + strings.ToLower(base32Hash),
+			want: &Magnet{InfoHash: hash},
+		},
+		{
+			name: "same hash in hex and base32",
+			link: "magnet:?xt=urn:btih:" + hash + "&xt.1=urn:btih:" + base32Hash,
+			want: &Magnet{InfoHash: hash},
+		},
+		{
+			name: "numbered trackers keep their order",
+			link: "magnet:?xt=urn:btih:" + hash + "&tr.2=wss://b.example&tr=wss://a.example&tr.1=udp://c.example:80",
+			want: &Magnet{InfoHash: hash, Trackers: []string{"wss://a.example", "udp://c.example:80", "wss://b.example"}},
+		},
+		{
+			name: "tr.10 after tr.2",
+			link: "magnet:?xt=urn:btih:" + hash + "&tr.10=wss://j.example&tr.2=wss://b.example&tr.1=wss://a.example",
+			want: &Magnet{InfoHash: hash, Trackers: []string{"wss://a.example", "wss://b.example", "wss://j.example"}},
+		},
+		{
+			name: "duplicate trackers dropped",
+			link: "magnet:?xt=urn:btih:" + hash + "&tr=wss://a.example&tr.1=wss://a.example",
+			want: &Magnet{InfoHash: hash, Trackers: []string{"wss://a.example"}},
+		},
+		{
+			name: "web seeds and exact sources",
+			link: "magnet:?xt=urn:btih:" + hash + "&ws=https://seed.example/film&xs=https://seed.example/film.torrent",
+			want: &Magnet{
+				InfoHash:     hash,
+				WebSeeds:     []string{"https://seed.example/film"},
+				ExactSources: []string{"https://seed.example/film.torrent"},
+			},
+		},
+		{
+			name: "other xt kinds skipped",
+			link: "magnet:?xt=urn:sha1:abc&xt.1=urn:btih:" + hash,
+			want: &Magnet{InfoHash: hash},
+		},
+		{name: "not a magnet link", link: "https://example.com/?xt=urn:btih:" + hash, wantErr: true},
+		{name: "no info hash", link: "magnet:?dn=film", wantErr: true},
+		{name: "short info hash", link: "magnet:?xt=urn:btih:dd8255ecdc", wantErr: true},
+		{name: "invalid hex", link: "magnet:?xt=urn:btih:zz8255ecdc7ca55fb0bbf81323d87062db1f6d1c", wantErr: true},
+		{name: "invalid base32", link: "magnet:?xt=urn:btih:11111111111111111111111111111111", wantErr: true},
+		{name: "two different info hashes", link: "magnet:?xt=urn:btih:" + hash + "&xt.1=urn:btih:0000000000000000000000000000000000000000", wantErr: true},
+		{name: "tracker with a bad scheme", link: "magnet:?xt=urn:btih:" + hash + "&tr=ftp://tracker.example", wantErr: true},
+		{name: "web seed without a host", link: "magnet:?xt=urn:btih:" + hash + "&ws=https:///film", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMagnet(tt.link)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseMagnet() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMagnet() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMagnet() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMagnetStringRoundTrip(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Trackers = []string{"wss://instance.example"}
+
+	magnet := &Magnet{
+		InfoHash:     "dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c",
+		Name:         "Big Buck Bunny",
+		Trackers:     []string{"wss://a.example", "wss://instance.example"},
+		WebSeeds:     []string{"https://seed.example/film"},
+		ExactSources: []string{"https://seed.example/film.torrent"},
+	}
+	got, err := parseMagnet(magnet.String())
+	if err != nil {
+		t.Fatalf("parseMagnet() error: %v", err)
+	}
+
+	want := *magnet
+	want.Trackers = []string{"wss://instance.example", "wss://a.example"}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("round trip = %+v, want %+v", *got, want)
+	}
+}
+
+func TestParseTorrentMatchesBuildTorrent(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	tests := []struct {
+		name      string
+		files     map[string]string // Path relative to the entry -> contents; "" for a single file
+		entry     string
+		wantFiles []FilmFile
+	}{
+		{
+			name:      "single file",
+			entry:     "film.mp4",
+			files:     map[string]string{"": "moving pictures"},
+			wantFiles: []FilmFile{{Path: "film.mp4", Length: 15}},
+		},
+		{
+			name:  "directory",
+			entry: "film",
+			files: map[string]string{"film.mp4": "moving pictures", "subs/en.srt": "words"},
+			wantFiles: []FilmFile{
+				{Path: "film/film.mp4", Length: 15},
+				{Path: "film/subs/en.srt", Length: 5},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.MediaDir = t.TempDir()
+			config.TorrentDir = t.TempDir()
+			config.PublicURL = "https://cinema.example"
+
+			for relative, contents := range tt.files {
+				full := filepath.Join(config.MediaDir, tt.entry, filepath.FromSlash(relative))
+				os.MkdirAll(filepath.Dir(full), 0755)
+				if err := os.WriteFile(full, []byte(contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			files, err := listLibraryFiles(tt.entry, len(tt.files) > 1)
+			if err != nil {
+				t.Fatalf("listLibraryFiles() error: %v", err)
+			}
+			entry, err := buildTorrent(tt.entry, files)
+			if err != nil {
+				t.Fatalf("buildTorrent() error: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(config.TorrentDir, entry.Torrent))
+			if err != nil {
+				t.Fatal(err)
+			}
+			magnet, parsedFiles, err := parseTorrent(data)
+			if err != nil {
+				t.Fatalf("parseTorrent() error: %v", err)
+			}
+
+			if magnet.InfoHash != entry.InfoHash {
+				t.Errorf("parseTorrent() info hash %s, buildTorrent() %s", magnet.InfoHash, entry.InfoHash)
+			}
+			if magnet.Name != tt.entry {
+				t.Errorf("name = %q, want %q", magnet.Name, tt.entry)
+			}
+			if !reflect.DeepEqual(magnet.Trackers, []string{"wss://cinema.example/announce"}) {
+				t.Errorf("trackers = %v", magnet.Trackers)
+			}
+			if !reflect.DeepEqual(parsedFiles, tt.wantFiles) || !reflect.DeepEqual(entry.filmFiles(), tt.wantFiles) {
+				t.Errorf("files = %+v and %+v, want %+v", parsedFiles, entry.filmFiles(), tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestParseTorrentRejects(t *testing.T) {
+	pieces := string(make([]byte, 20))
+	tests := []struct {
+		name string
+		info map[string]interface{}
+	}{
+		{"no name", map[string]interface{}{"piece length": 16384, "pieces": pieces, "length": 1}},
+		{"name with a slash", map[string]interface{}{"name": "a/b", "piece length": 16384, "pieces": pieces, "length": 1}},
+		{"dot-dot name", map[string]interface{}{"name": "..", "piece length": 16384, "pieces": pieces, "length": 1}},
+		{"no piece length", map[string]interface{}{"name": "film", "pieces": pieces, "length": 1}},
+		{"truncated pieces", map[string]interface{}{"name": "film", "piece length": 16384, "pieces": pieces[:19], "length": 1}},
+		{"no files", map[string]interface{}{"name": "film", "piece length": 16384, "pieces": pieces}},
+		{"unsafe file path", map[string]interface{}{"name": "film", "piece length": 16384, "pieces": pieces,
+			"files": []interface{}{map[string]interface{}{"length": 1, "path": []interface{}{"..", "etc"}}}}},
+		{"empty file path", map[string]interface{}{"name": "film", "piece length": 16384, "pieces": pieces,
+			"files": []interface{}{map[string]interface{}{"length": 1, "path": []interface{}{}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bencode(map[string]interface{}{"info": tt.info})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := parseTorrent(data); err == nil {
+				t.Error("parseTorrent() succeeded")
+			}
+		})
+	}
+}
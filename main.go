@@ -6,6 +6,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-contrib/static"
@@ -20,26 +23,109 @@ type Config struct {
 	JWTSecret    string `json:"jwt_secret"`
 	ServerPort   string `json:"server_port"`
 	StaticFolder string `json:"static_folder"`
+	PublicURL    string `json:"public_url"` // e.g. https://virtuaplex.net; derived from requests when empty
+	MediaDir     string `json:"media_dir"`  // Local film library served as HTTP web seeds
+
+	// Reverse proxies whose X-Forwarded-For is believed; with none, the
+	// client IP is the connection's remote address
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// Media library scanner
+	TorrentDir          string        `json:"torrent_dir"` // Where generated .torrent files and the scan index go
+	LibraryScanInterval time.Duration `json:"library_scan_interval"`
+
+	// Embedded WebTorrent tracker
+	TrackerInterval       time.Duration `json:"tracker_interval"`
+	TrackerMaxConnections int           `json:"tracker_max_connections"` // Peers the tracker matches each peer with
+	Trackers              []string      `json:"trackers"`                // Public trackers put in every film's magnet link
+
+	// ICE servers for browser peer connections
+	STUNPort          string        `json:"stun_port"` // UDP port of the embedded STUN server, empty to disable
+	STUNServers       []string      `json:"stun_servers"`
+	TURNServers       []string      `json:"turn_servers"`
+	TURNSecret        string        `json:"turn_secret"` // Shared with the TURN server's use-auth-secret
+	TURNCredentialTTL time.Duration `json:"turn_credential_ttl"`
+
+	// Swarm telemetry and pre-show warming
+	SwarmReportInterval time.Duration `json:"swarm_report_interval"` // How often clients send swarm stats
+	PrewarmLead         time.Duration `json:"prewarm_lead"`          // How long before a screening its swarm is checked
+	PrewarmWebSeed      bool          `json:"prewarm_web_seed"`      // Read the start of cold films' media files ahead of time
+
+	// Position relay
+	PositionRadius      float64       `json:"position_radius"`
+	PositionTick        time.Duration `json:"position_tick"`
+	PositionMinInterval time.Duration `json:"position_min_interval"`
+
+	// Playback clock
+	PlaybackSyncInterval time.Duration `json:"playback_sync_interval"`
+
+	// Operator accounts
+	Operators []OperatorAccount `json:"operators"`
+
+	// Chat
+	ChatHistorySize int `json:"chat_history_size"`
+	ChatMaxLength   int `json:"chat_max_length"`
+
+	// Moderation
+	WordFilter        []string      `json:"word_filter"`
+	ModerationLogSize int           `json:"moderation_log_size"`
+	KickCooldown      time.Duration `json:"kick_cooldown"` // How long a kicked address stays out of the theater
+
+	// Rate limiting
+	RateLimits             map[string]RateLimit `json:"rate_limits"`
+	RateLimitMaxViolations int                  `json:"rate_limit_max_violations"` // Per minute before a WebSocket is closed
+
+	// Seat holds
+	SeatHoldTTL  time.Duration `json:"seat_hold_ttl"`  // While a visitor is choosing
+	PartyHoldTTL time.Duration `json:"party_hold_ttl"` // While a party's friends arrive
+	MaxPartySize int           `json:"max_party_size"`
+
+	// RSVPs
+	RSVPGracePeriod time.Duration `json:"rsvp_grace_period"` // After start before a reservation is given away
+
+	// Lobbies
+	MaxLobbiesPerTheater int `json:"max_lobbies_per_theater"` // Lobbies showing at once across a theater's schedules
+
+	// Watch parties
+	WatchPartiesEnabled bool `json:"watch_parties_enabled"`
+	WatchPartyLimit     int  `json:"watch_party_limit"` // Concurrent rooms per visitor
+
+	// Proof-of-work admission
+	PowEnabled        bool          `json:"pow_enabled"`
+	PowDifficulty     int           `json:"pow_difficulty"`      // Leading zero bits
+	PowMaxDifficulty  int           `json:"pow_max_difficulty"`  // Cap when join rates spike
+	PowSpikeThreshold int           `json:"pow_spike_threshold"` // Joins per minute before difficulty rises
+	PowChallengeTTL   time.Duration `json:"pow_challenge_ttl"`
 }
 
 // Screening represents a movie screening
 type Screening struct {
-	ID         string    `json:"id"`
-	Title      string    `json:"title"`
-	MagnetLink string    `json:"magnet_link"`
-	StartTime  time.Time `json:"start_time"`
-	EndTime    time.Time `json:"end_time"`
-	Seats      *Seats    `json:"seats"`
+	ID         string          `json:"id"`
+	ScheduleID string          `json:"schedule_id"` // Shared by all lobbies of the same showing
+	TheaterID  string          `json:"theater_id"`
+	FilmID     string          `json:"film_id,omitempty"`
+	Overflow   bool            `json:"overflow"` // Extra lobby opened when the others filled up
+	Title      string          `json:"title"`
+	MagnetLink string          `json:"magnet_link"`
+	Files      *FileSelection  `json:"file_selection,omitempty"` // Set when the torrent has several files
+	Subtitles  []SubtitleTrack `json:"subtitles,omitempty"`      // Filled in per response from the film's tracks
+	StartTime  time.Time       `json:"start_time"`
+	EndTime    time.Time       `json:"end_time"`
+	Seats      *Seats          `json:"seats"`
+	Playback   *Playback       `json:"playback"`
 }
 
 // Seats represents the theater seats
 type Seats struct {
 	Rows        int            `json:"rows"`
 	SeatsPerRow int            `json:"seats_per_row"`
+	Layout      *SeatLayout    `json:"layout"`
 	Occupied    []SeatPosition `json:"occupied"`
+	Held        []SeatHold     `json:"held"`
 }
 
-// SeatPosition represents a seat position
+// SeatPosition represents a seat position: a row index and a position
+// within that row's layout, counting aisles
 type SeatPosition struct {
 	Row       int    `json:"row"`
 	Seat      int    `json:"seat"`
@@ -52,7 +138,19 @@ type Visitor struct {
 	Name        string        `json:"name"`
 	ScreeningID string        `json:"screening_id"`
 	Seat        *SeatPosition `json:"seat,omitempty"`
+	Transform   *Transform    `json:"transform,omitempty"`
 	LastActive  time.Time     `json:"last_active"`
+	MutedUntil  time.Time     `json:"muted_until"`
+	PartyCode   string        `json:"-"` // Party whose held seats this visitor may take
+	RSVPCode    string        `json:"-"` // RSVP the visitor arrived with
+	IPHash      string        `json:"-"`
+
+	grant          string          // Private screening access carried in the visitor's token
+	queuedFor      string          // Schedule whose admission queue the visitor is waiting in
+	queuePosition  int             // Last queue position sent to the visitor
+	positionDirty  bool            // Transform changed since the last position tick
+	lastPositionAt time.Time       // When the last accepted position update arrived
+	inRange        map[string]bool // Visitor IDs this visitor currently receives positions for
 }
 
 // WebSocketMessage represents a message sent over WebSocket
@@ -64,10 +162,13 @@ type WebSocketMessage struct {
 // Global variables
 var (
 	config     Config
+	mu         sync.Mutex // Guards screenings, visitors and clients; WebSocket writes go through sendJSON
 	screenings = make(map[string]*Screening)
 	visitors   = make(map[string]*Visitor)
 	clients    = make(map[*websocket.Conn]string) // WebSocket connection -> visitor ID
-	upgrader   = websocket.Upgrader{
+	// WebSocket connection -> operator, for connections that presented an operator token
+	operatorConns = make(map[*websocket.Conn]*Operator)
+	upgrader      = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 		CheckOrigin: func(r *http.Request) bool {
@@ -86,29 +187,148 @@ func main() {
 	// Set up Gin router
 	router := gin.Default()
 
+	// Bans, room limits and per-IP rate limits key on the client IP, which
+	// clients could otherwise pick through X-Forwarded-For
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Serve static files
 	router.Use(static.Serve("/", static.LocalFile(config.StaticFolder, false)))
 
 	// API routes
-	api := router.Group("/api")
+	api := router.Group("/api", rateLimitByIP("api"))
 	{
 		// Authentication
-		api.POST("/auth/visitor", createVisitorToken)
+		api.GET("/auth/challenge", rateLimitByIP("challenge"), createChallenge)
+		api.POST("/auth/visitor", rateLimitByIP("auth"), createVisitorToken)
+		api.POST("/auth/operator", rateLimitByIP("auth"), createOperatorToken)
+		api.GET("/ice-servers", rateLimitByVisitor("ice"), getICEServers)
 
 		// Screenings
 		screeningsAPI := api.Group("/screenings")
-		screeningsAPI.GET("/:id", getScreening)
-		screeningsAPI.POST("/:id/seats", selectSeat)
-		screeningsAPI.POST("/:id/seats/release", releaseSeat)
-		screeningsAPI.POST("/:id/heartbeat", heartbeat)
+		screeningsAPI.GET("", listScreenings)
+		screeningsAPI.GET("/:id", requireAccess(), getScreening)
+		screeningsAPI.POST("/:id/seats", requireAccess(), rateLimitByVisitor("seat"), selectSeat)
+		screeningsAPI.POST("/:id/seats/release", requireAccess(), rateLimitByVisitor("seat"), releaseSeat)
+		screeningsAPI.POST("/:id/seats/hold", requireAccess(), rateLimitByVisitor("seat"), holdSeat)
+		screeningsAPI.POST("/:id/seats/hold/release", requireAccess(), rateLimitByVisitor("seat"), releaseSeatHold)
+		screeningsAPI.POST("/:id/seats/group", requireAccess(), rateLimitByVisitor("seat"), reserveGroupSeats)
+		screeningsAPI.POST("/:id/parties/:code/join", requireAccess(), rateLimitByVisitor("seat"), joinParty)
+		screeningsAPI.GET("/:id/rsvp", getRSVPSummary)
+		screeningsAPI.POST("/:id/rsvp", rateLimitByIP("rsvp"), createRSVP)
+		screeningsAPI.GET("/:id/rsvp/:code", getRSVP)
+		screeningsAPI.POST("/:id/rsvp/:code/cancel", rateLimitByIP("rsvp"), cancelRSVP)
+		screeningsAPI.POST("/:id/heartbeat", requireAccess(), heartbeat)
+		screeningsAPI.POST("/:id/signal", requireAccess(), rateLimitByVisitor("signal"), sendSignal)
+		screeningsAPI.GET("/:id/signal", requireAccess(), pollSignals)
+		screeningsAPI.GET("/:id/chat", requireAccess(), getChatMessages)
+		screeningsAPI.POST("/:id/chat", requireAccess(), rateLimitByVisitor("chat"), sendChatMessage)
+
+		// Films
+		filmsAPI := api.Group("/films")
+		filmsAPI.GET("", listFilms)
+		filmsAPI.GET("/:id", getFilm)
+		filmsAPI.POST("", requireRole(RoleManager), createFilm)
+		filmsAPI.PUT("/:id", requireRole(RoleManager), updateFilm)
+		filmsAPI.DELETE("/:id", requireRole(RoleManager), deleteFilm)
+		filmsAPI.GET("/:id/subtitles", listSubtitles)
+		filmsAPI.GET("/:id/subtitles/:file", serveSubtitle)
+		filmsAPI.PUT("/:id/subtitles/:lang", requireRole(RoleManager), uploadSubtitle)
+		filmsAPI.DELETE("/:id/subtitles/:lang", requireRole(RoleManager), deleteSubtitle)
+
+		// Visitor watch parties
+		roomsAPI := api.Group("/rooms")
+		roomsAPI.POST("", rateLimitByVisitor("room"), createRoom)
+		roomsAPI.POST("/:id/projection", requireAccess(), controlRoom)
+
+		// Lobbies share screening state for now
+		lobbiesAPI := api.Group("/lobbies")
+		lobbiesAPI.GET("/:id/chat", requireAccess(), getChatMessages)
+		lobbiesAPI.POST("/:id/chat", requireAccess(), rateLimitByVisitor("chat"), sendChatMessage)
+
+		// Operator controls
+		operatorAPI := api.Group("/operator")
+		operatorAPI.POST("/screenings", requireRole(RoleManager), createScreening)
+		operatorAPI.POST("/screenings/:id/projection", requireRole(RoleProjectionist), controlProjection)
+		operatorAPI.PUT("/schedules/:id/access", requireRole(RoleManager), updateScheduleAccess)
+		operatorAPI.PUT("/schedules/:id/files", requireRole(RoleManager), updateScheduleFiles)
+		operatorAPI.GET("/invites", requireRole(RoleManager), listInvites)
+		operatorAPI.POST("/invites", requireRole(RoleManager), createInvite)
+		operatorAPI.DELETE("/invites/:id", requireRole(RoleManager), deleteInvite)
+		operatorAPI.GET("/library", requireRole(RoleManager), getLibrary)
+		operatorAPI.POST("/library/scan", requireRole(RoleManager), startLibraryScan)
+		operatorAPI.GET("/swarm", requireRole(RoleProjectionist), getSwarmHealth)
+		operatorAPI.POST("/stream-ticket", requireRole(RoleProjectionist), createStreamTicket)
+		operatorAPI.GET("/swarm/stream", requireRole(RoleProjectionist), streamSwarmHealth)
+		operatorAPI.GET("/swarm/:id", requireRole(RoleProjectionist), getScreeningSwarmHealth)
+		operatorAPI.GET("/readiness", requireRole(RoleProjectionist), getReadiness)
+		operatorAPI.GET("/watch-parties", requireRole(RoleAdmin), getWatchPartySettings)
+		operatorAPI.PUT("/watch-parties", requireRole(RoleAdmin), updateWatchPartySettings)
+
+		// Theaters
+		theatersAPI := api.Group("/theaters")
+		theatersAPI.GET("/:id/layout", getTheaterLayout)
+		theatersAPI.PUT("/:id/layout", requireRole(RoleManager), updateTheaterLayout)
+		theatersAPI.PUT("/:id/access", requireRole(RoleManager), updateTheaterAccess)
+
+		// Moderation
+		moderationAPI := api.Group("/moderation", requireRole(RoleModerator))
+		moderationAPI.DELETE("/screenings/:id/chat/:message_id", deleteChatMessage)
+		moderationAPI.POST("/visitors/:id/mute", muteVisitor)
+		moderationAPI.POST("/visitors/:id/kick", kickVisitor)
+		moderationAPI.GET("/bans", listBans)
+		moderationAPI.POST("/bans", createBan)
+		moderationAPI.DELETE("/bans/:id", deleteBan)
+		moderationAPI.GET("/log", getModerationLog)
 	}
 
 	// WebSocket handler
 	router.GET("/ws/screenings/:id", handleWebSocket)
 
+	// WebTorrent tracker, announced in every screening's magnet link
+	router.GET("/announce", handleTracker)
+
+	// Web seeds from the local media library
+	router.GET("/webseed/*path", serveWebSeed)
+	router.HEAD("/webseed/*path", serveWebSeed)
+	router.GET("/torrents/:file", serveTorrentFile)
+
 	// Start cleanup routine
 	go cleanupInactiveVisitors()
 
+	// Start position relay
+	go broadcastPositions()
+
+	// Start playback clock sync
+	go broadcastPlaybackSync()
+
+	// Start seat hold expiry
+	go expireSeatHolds()
+
+	// Start RSVP no-show expiry
+	go expireRSVPs()
+
+	// Start admission queues
+	go admitQueuedVisitors()
+
+	// Start watch party room cleanup
+	go expireRooms()
+
+	// Start media library scanner
+	go runLibraryScanner()
+
+	// Start pre-show swarm checks
+	go prewarmScreenings()
+
+	// Start embedded STUN server
+	if config.STUNPort != "" {
+		go runSTUNServer()
+	}
+
+	// Start rate limiter cleanup
+	go sweepRateLimiters()
+
 	// Start server
 	log.Printf("Starting server on port %s", config.ServerPort)
 	if err := router.Run(":" + config.ServerPort); err != nil {
@@ -120,7 +340,48 @@ func main() {
 func loadConfig() {
 	config.JWTSecret = getEnv("JWT_SECRET", "virtuaplex-secret-key-change-in-production")
 	config.ServerPort = getEnv("PORT", "8080")
+	config.PublicURL = getEnv("PUBLIC_URL", "")
+	config.MediaDir = getEnv("MEDIA_DIR", "")
+	config.TrustedProxies = parseList(getEnv("TRUSTED_PROXIES", ""))
+	config.TorrentDir = getEnv("TORRENT_DIR", "./torrents")
+	config.LibraryScanInterval = getEnvDuration("LIBRARY_SCAN_INTERVAL", 10*time.Minute)
+	config.TrackerInterval = getEnvPositiveDuration("TRACKER_INTERVAL", 2*time.Minute)
+	config.TrackerMaxConnections = getEnvInt("TRACKER_MAX_CONNECTIONS", 30)
+	config.STUNPort = getEnv("STUN_PORT", "")
+	config.STUNServers = strings.Split(getEnv("STUN_SERVERS", "stun:stun.l.google.com:19302,stun:stun1.l.google.com:19302"), ",")
+	config.TURNServers = strings.Split(getEnv("TURN_SERVERS", ""), ",")
+	config.TURNSecret = getEnv("TURN_SECRET", "")
+	config.TURNCredentialTTL = getEnvDuration("TURN_CREDENTIAL_TTL", time.Hour)
+	config.SwarmReportInterval = getEnvPositiveDuration("SWARM_REPORT_INTERVAL", 10*time.Second)
+	config.PrewarmLead = getEnvDuration("PREWARM_LEAD", 15*time.Minute)
+	config.PrewarmWebSeed = getEnvBool("PREWARM_WEB_SEED", false)
+	config.Trackers = strings.Split(getEnv("TRACKERS", "wss://tracker.openwebtorrent.com,wss://tracker.webtorrent.dev"), ",")
 	config.StaticFolder = getEnv("STATIC_FOLDER", "./static")
+	config.PositionRadius = getEnvFloat("POSITION_RADIUS", 30)
+	config.PositionTick = getEnvPositiveDuration("POSITION_TICK", 100*time.Millisecond)
+	config.PositionMinInterval = getEnvDuration("POSITION_MIN_INTERVAL", 50*time.Millisecond)
+	config.PlaybackSyncInterval = getEnvPositiveDuration("PLAYBACK_SYNC_INTERVAL", 5*time.Second)
+	config.Operators = parseOperators(getEnv("OPERATORS", ""))
+	config.ChatHistorySize = getEnvPositiveInt("CHAT_HISTORY_SIZE", 200)
+	config.ChatMaxLength = getEnvPositiveInt("CHAT_MAX_LENGTH", 500)
+	config.WordFilter = strings.Split(getEnv("WORD_FILTER", ""), ",")
+	config.ModerationLogSize = getEnvPositiveInt("MODERATION_LOG_SIZE", 1000)
+	config.KickCooldown = getEnvDuration("KICK_COOLDOWN", 10*time.Minute)
+	wordFilter = compileWordFilter(config.WordFilter)
+	config.RateLimits = parseRateLimits(getEnv("RATE_LIMITS", ""))
+	config.RateLimitMaxViolations = getEnvInt("RATE_LIMIT_MAX_VIOLATIONS", 20)
+	config.SeatHoldTTL = getEnvDuration("SEAT_HOLD_TTL", time.Minute)
+	config.PartyHoldTTL = getEnvDuration("PARTY_HOLD_TTL", 10*time.Minute)
+	config.MaxPartySize = getEnvInt("MAX_PARTY_SIZE", 10)
+	config.RSVPGracePeriod = getEnvDuration("RSVP_GRACE_PERIOD", 10*time.Minute)
+	config.MaxLobbiesPerTheater = getEnvInt("MAX_LOBBIES_PER_THEATER", 4)
+	config.WatchPartiesEnabled = getEnvBool("WATCH_PARTIES_ENABLED", true)
+	config.WatchPartyLimit = getEnvInt("WATCH_PARTY_LIMIT", 1)
+	config.PowEnabled = getEnvBool("POW_ENABLED", false)
+	config.PowDifficulty = getEnvInt("POW_DIFFICULTY", 16)
+	config.PowMaxDifficulty = getEnvInt("POW_MAX_DIFFICULTY", 24)
+	config.PowSpikeThreshold = getEnvInt("POW_SPIKE_THRESHOLD", 60)
+	config.PowChallengeTTL = getEnvDuration("POW_CHALLENGE_TTL", 2*time.Minute)
 }
 
 // Get environment variable with fallback
@@ -131,20 +392,128 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// Split a comma-separated list, dropping empty entries
+func parseList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Get boolean environment variable with fallback
+func getEnvBool(key string, fallback bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid value for %s, using default: %v", key, err)
+		return fallback
+	}
+	return parsed
+}
+
+// Get integer environment variable with fallback
+func getEnvInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s, using default: %v", key, err)
+		return fallback
+	}
+	return parsed
+}
+
+// Get integer environment variable that must be above zero, such as a buffer size, with fallback
+func getEnvPositiveInt(key string, fallback int) int {
+	value := getEnvInt(key, fallback)
+	if value <= 0 {
+		log.Printf("Invalid value for %s, using default: must be positive", key)
+		return fallback
+	}
+	return value
+}
+
+// Get float environment variable with fallback
+func getEnvFloat(key string, fallback float64) float64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s, using default: %v", key, err)
+		return fallback
+	}
+	return parsed
+}
+
+// Get duration environment variable (e.g. "100ms") with fallback
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid value for %s, using default: %v", key, err)
+		return fallback
+	}
+	return parsed
+}
+
+// Get duration environment variable that must be above zero, such as a ticker interval, with fallback
+func getEnvPositiveDuration(key string, fallback time.Duration) time.Duration {
+	value := getEnvDuration(key, fallback)
+	if value <= 0 {
+		log.Printf("Invalid value for %s, using default: must be positive", key)
+		return fallback
+	}
+	return value
+}
+
 // Initialize default screening
 func initDefaultScreening() {
 	screeningID := "default"
+	startTime := time.Now()
+	theaterLayouts["default"] = rectangularLayout(5, 10)
+	magnet, _ := parseMagnet("magnet:?xt=urn:btih:dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c&dn=Big+Buck+Bunny&tr=udp%3A%2F%2Fexplodie.org%3A6969&tr=udp%3A%2F%2Ftracker.coppersurfer.tk%3A6969&tr=udp%3A%2F%2Ftracker.empire-js.us%3A1337&tr=udp%3A%2F%2Ftracker.leechers-paradise.org%3A6969&tr=udp%3A%2F%2Ftracker.opentrackr.org%3A1337&tr=wss%3A%2F%2Ftracker.btorrent.xyz&tr=wss%3A%2F%2Ftracker.fastcast.nz&tr=wss%3A%2F%2Ftracker.openwebtorrent.com&ws=https%3A%2F%2Fwebtorrent.io%2Ftorrents%2F&xs=https%3A%2F%2Fwebtorrent.io%2Ftorrents%2Fbig-buck-bunny.torrent")
+	films["big-buck-bunny"] = &Film{
+		ID:              "big-buck-bunny",
+		Title:           "Big Buck Bunny",
+		ReleaseYear:     2008,
+		DurationMinutes: 10,
+		MagnetLink:      magnet.String(),
+		InfoHash:        magnet.InfoHash,
+		IsPublicDomain:  true,
+		AddedBy:         "system",
+		AddedAt:         startTime,
+	}
+	film := films["big-buck-bunny"]
 	screenings[screeningID] = &Screening{
 		ID:         screeningID,
-		Title:      "Big Buck Bunny",
-		MagnetLink: "magnet:?xt=urn:btih:dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c&dn=Big+Buck+Bunny&tr=udp%3A%2F%2Fexplodie.org%3A6969&tr=udp%3A%2F%2Ftracker.coppersurfer.tk%3A6969&tr=udp%3A%2F%2Ftracker.empire-js.us%3A1337&tr=udp%3A%2F%2Ftracker.leechers-paradise.org%3A6969&tr=udp%3A%2F%2Ftracker.opentrackr.org%3A1337&tr=wss%3A%2F%2Ftracker.btorrent.xyz&tr=wss%3A%2F%2Ftracker.fastcast.nz&tr=wss%3A%2F%2Ftracker.openwebtorrent.com&ws=https%3A%2F%2Fwebtorrent.io%2Ftorrents%2F&xs=https%3A%2F%2Fwebtorrent.io%2Ftorrents%2Fbig-buck-bunny.torrent",
-		StartTime:  time.Now(),
-		EndTime:    time.Now().Add(24 * time.Hour), // Make it last a full day
+		ScheduleID: screeningID,
+		TheaterID:  "default",
+		FilmID:     film.ID,
+		Title:      film.Title,
+		MagnetLink: film.MagnetLink,
+		StartTime:  startTime,
+		EndTime:    startTime.Add(24 * time.Hour), // Make it last a full day
 		Seats: &Seats{
 			Rows:        5,
 			SeatsPerRow: 10,
+			Layout:      theaterLayouts["default"],
 			Occupied:    []SeatPosition{},
+			Held:        []SeatHold{},
 		},
+		Playback: newPlayback(startTime),
 	}
 }
 
@@ -153,6 +522,11 @@ func createVisitorToken(c *gin.Context) {
 	var request struct {
 		ScreeningID string `json:"screening_id" binding:"required"`
 		VisitorName string `json:"visitor_name" binding:"required"`
+		ChallengeID string `json:"challenge_id"`
+		Solution    string `json:"solution"`
+		RSVPCode    string `json:"rsvp_code"`
+		Passcode    string `json:"passcode"`
+		Invite      string `json:"invite"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -160,6 +534,9 @@ func createVisitorToken(c *gin.Context) {
 		return
 	}
 
+	mu.Lock()
+	defer mu.Unlock()
+
 	/* Check if screening exists - use default if user-supplied screening doesn't exist
 	screening, exists := screenings[request.ScreeningID]
 	if !exists {
@@ -167,32 +544,111 @@ func createVisitorToken(c *gin.Context) {
 		request.ScreeningID = "default"
 	}*/
 
+	// Require a solved proof-of-work challenge when enabled
+	now := time.Now()
+	if config.PowEnabled && !verifyChallenge(request.ChallengeID, request.Solution, now) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Proof of work required", "challenge_required": true})
+		return
+	}
+
+	if containsFilteredWord(request.VisitorName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Display name is not allowed"})
+		return
+	}
+
+	// Refuse banned addresses
+	ipHash := hashIP(c.ClientIP())
+	if ban := findBan("", ipHash, theaterOf(request.ScreeningID)); ban != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Banned", "reason": ban.Reason})
+		return
+	}
+	if until := kickedUntil(ipHash, theaterOf(request.ScreeningID)); !until.IsZero() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Kicked", "until": until})
+		return
+	}
+
+	// Private screenings need a passcode, an invite, or an RSVP made with one
+	screening, exists := screenings[request.ScreeningID]
+	if !exists {
+		screening = screenings["default"]
+	}
+	grant := ""
+	rsvp, hasRSVP := rsvps[strings.ToUpper(request.RSVPCode)]
+	hasRSVP = hasRSVP && rsvp.ScreeningID == screening.ID
+
+	// Only a confirmed RSVP nobody has used yet stands in for the invite, so
+	// sharing a code can't get around an invite's max uses
+	confirmedRSVP := hasRSVP && rsvp.Status == RSVPConfirmed && rsvp.VisitorID == ""
+	if confirmedRSVP {
+		grant = rsvp.Grant
+	}
+	if grant == "" {
+		var status int
+		var response gin.H
+		if grant, status, response = admit(screening, request.Passcode, request.Invite, now); response != nil {
+			c.JSON(status, response)
+			return
+		}
+	}
+
+	// Place the visitor in a lobby with room; confirmed RSVPs already hold a
+	// place in theirs. Nobody gets in ahead of visitors already waiting.
+	lobby := screening
+	if !confirmedRSVP {
+		lobby = nil
+		if len(admissionQueues[screening.ScheduleID]) == 0 {
+			lobby = findLobby(screening, now)
+		}
+	}
+
 	// Create a new visitor
 	visitorID := uuid.New().String()
-	visitors[visitorID] = &Visitor{
-		ID:          visitorID,
-		Name:        request.VisitorName,
-		ScreeningID: request.ScreeningID,
-		LastActive:  time.Now(),
+	visitor := &Visitor{
+		ID:         visitorID,
+		Name:       request.VisitorName,
+		LastActive: time.Now(),
+		IPHash:     ipHash,
+		grant:      grant,
 	}
+	visitor.MutedUntil = mutedUntil(visitor)
+	visitors[visitorID] = visitor
+	recordJoin(now)
 
-	// Create JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":          visitorID,
-		"name":         request.VisitorName,
-		"screening_id": request.ScreeningID,
-		"iat":          time.Now().Unix(),
-		"exp":          time.Now().Add(3 * time.Hour).Unix(),
-	})
+	// Everything is full: wait in line, keeping the token for the requested screening
+	if lobby == nil {
+		enqueue(visitor, screening.ScheduleID)
+
+		tokenString, err := signVisitorToken(visitorID, request.VisitorName, screening.ID, grant)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
 
-	tokenString, err := token.SignedString([]byte(config.JWTSecret))
+		c.JSON(http.StatusAccepted, gin.H{
+			"token":          tokenString,
+			"visitor_id":     visitorID,
+			"screening_id":   screening.ID,
+			"queued":         true,
+			"queue_position": visitor.queuePosition,
+		})
+		return
+	}
+	visitor.ScreeningID = lobby.ID
+
+	// Visitors arriving with an RSVP get their reserved seat
+	if hasRSVP {
+		claimRSVP(visitor, request.RSVPCode)
+	}
+
+	// Create JWT token
+	tokenString, err := signVisitorToken(visitorID, request.VisitorName, lobby.ID, grant)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
 		return
 	}
 
 	// Broadcast visitor joined event
-	broadcastToScreening(request.ScreeningID, WebSocketMessage{
+	broadcastToScreening(lobby.ID, WebSocketMessage{
 		Type: "visitor_joined",
 		Data: gin.H{
 			"visitor": gin.H{
@@ -203,13 +659,34 @@ func createVisitorToken(c *gin.Context) {
 	})
 
 	c.JSON(http.StatusOK, gin.H{
-		"token":      tokenString,
-		"visitor_id": visitorID,
+		"token":        tokenString,
+		"visitor_id":   visitorID,
+		"screening_id": lobby.ID,
 	})
 }
 
+// Sign a visitor JWT, carrying the access grant for private screenings
+func signVisitorToken(visitorID, name, screeningID, grant string) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":          visitorID,
+		"name":         name,
+		"screening_id": screeningID,
+		"iat":          time.Now().Unix(),
+		"exp":          time.Now().Add(3 * time.Hour).Unix(),
+	}
+	if grant != "" {
+		claims["access"] = grant
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(config.JWTSecret))
+}
+
 // Get screening details
 func getScreening(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
 	// Verify token
 	visitorID, err := verifyToken(c)
 	if err != nil {
@@ -230,11 +707,14 @@ func getScreening(c *gin.Context) {
 	visitor := visitors[visitorID]
 	visitor.LastActive = time.Now()
 
-	c.JSON(http.StatusOK, screening)
+	c.JSON(http.StatusOK, screeningResponse(c, screening, visitorID))
 }
 
 // Select a seat
 func selectSeat(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
 	// Verify token
 	visitorID, err := verifyToken(c)
 	if err != nil {
@@ -244,10 +724,10 @@ func selectSeat(c *gin.Context) {
 
 	screeningID := c.Param("id")
 
-	// Parse request
+	// Parse request (pointers so that row and seat 0 pass "required")
 	var request struct {
-		RowNumber  int `json:"row_number" binding:"required"`
-		SeatNumber int `json:"seat_number" binding:"required"`
+		RowNumber  *int `json:"row_number" binding:"required"`
+		SeatNumber *int `json:"seat_number" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -262,58 +742,63 @@ func selectSeat(c *gin.Context) {
 		screeningID = "default"
 	}
 
-	// Check if seat is valid
-	if request.RowNumber < 0 || request.RowNumber >= screening.Seats.Rows ||
-		request.SeatNumber < 0 || request.SeatNumber >= screening.Seats.SeatsPerRow {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid seat"})
+	// Get visitor
+	visitor := visitors[visitorID]
+
+	// Check the seat exists in the layout, is free and isn't held for someone else
+	if status, message := checkSeat(screening, *request.RowNumber, *request.SeatNumber, visitor); message != "" {
+		c.JSON(status, gin.H{"error": message})
 		return
 	}
 
-	// Check if seat is occupied
-	for _, seat := range screening.Seats.Occupied {
-		if seat.Row == request.RowNumber && seat.Seat == request.SeatNumber {
-			c.JSON(http.StatusConflict, gin.H{"error": "Seat is already occupied"})
-			return
-		}
-	}
+	// Assign new seat
+	newSeat := assignSeat(screening, visitor, *request.RowNumber, *request.SeatNumber)
 
-	// Get visitor
-	visitor := visitors[visitorID]
+	// Broadcast seat update
+	broadcastToScreening(screeningID, WebSocketMessage{
+		Type: "seat_update",
+		Data: screening.Seats,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"seat":    newSeat,
+	})
+}
 
+// Seat a visitor, releasing any seat they had and any hold on the new one.
+// The seat must have passed checkSeat. Must be called with mu held.
+func assignSeat(screening *Screening, visitor *Visitor, row, position int) SeatPosition {
 	// If visitor already has a seat, release it
 	if visitor.Seat != nil {
 		for i, seat := range screening.Seats.Occupied {
-			if seat.VisitorID == visitorID {
+			if seat.VisitorID == visitor.ID {
 				screening.Seats.Occupied = append(screening.Seats.Occupied[:i], screening.Seats.Occupied[i+1:]...)
 				break
 			}
 		}
 	}
 
-	// Assign new seat
+	// Taking a seat ends the hold on it and any the visitor had elsewhere
+	screening.Seats.removeHold(row, position)
+	screening.Seats.removeVisitorHolds(visitor.ID)
+
 	newSeat := SeatPosition{
-		Row:       request.RowNumber,
-		Seat:      request.SeatNumber,
-		VisitorID: visitorID,
+		Row:       row,
+		Seat:      position,
+		VisitorID: visitor.ID,
 	}
 	screening.Seats.Occupied = append(screening.Seats.Occupied, newSeat)
 	visitor.Seat = &newSeat
 	visitor.LastActive = time.Now()
-
-	// Broadcast seat update
-	broadcastToScreening(screeningID, WebSocketMessage{
-		Type: "seat_update",
-		Data: screening.Seats,
-	})
-
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"seat":    newSeat,
-	})
+	return newSeat
 }
 
 // Release a seat
 func releaseSeat(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
 	// Verify token
 	visitorID, err := verifyToken(c)
 	if err != nil {
@@ -358,6 +843,9 @@ func releaseSeat(c *gin.Context) {
 
 // Heartbeat to keep visitor active
 func heartbeat(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
 	// Verify token
 	visitorID, err := verifyToken(c)
 	if err != nil {
@@ -377,7 +865,9 @@ func handleWebSocket(c *gin.Context) {
 	screeningID := c.Param("id")
 
 	// If screening doesn't exist, use default
+	mu.Lock()
 	_, exists := screenings[screeningID]
+	mu.Unlock()
 	if !exists {
 		screeningID = "default"
 	}
@@ -388,12 +878,16 @@ func handleWebSocket(c *gin.Context) {
 		log.Printf("Failed to upgrade to WebSocket: %v", err)
 		return
 	}
+	startWriter(conn)
 
 	// WebSocket connection will be authenticated after receiving the first message
 	// which should contain the authentication token
 
 	// Set up clean-up when connection is closed
 	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+
 		// If connection is authenticated, clean up visitor data
 		if visitorID, ok := clients[conn]; ok {
 			// Get visitor
@@ -419,6 +913,14 @@ func handleWebSocket(c *gin.Context) {
 					}
 				}
 
+				// Drop any seat the visitor was holding while choosing
+				if screening, exists := screenings[visitor.ScreeningID]; exists && screening.Seats.removeVisitorHolds(visitorID) {
+					broadcastSeats(visitor.ScreeningID, screening)
+				}
+
+				// An RSVP's place goes to the waitlist once its visitor leaves
+				leaveRSVP(visitor)
+
 				// Broadcast visitor left event
 				broadcastToScreening(visitor.ScreeningID, WebSocketMessage{
 					Type: "visitor_left",
@@ -431,10 +933,17 @@ func handleWebSocket(c *gin.Context) {
 			// Remove client from map
 			delete(clients, conn)
 		}
+		delete(operatorConns, conn)
 
+		stopWriter(conn)
 		conn.Close()
 	}()
 
+	// Rate limit violations in the current one-minute window
+	clientIP := c.ClientIP()
+	violations := 0
+	windowStart := time.Now()
+
 	// Listen for messages
 	for {
 		_, message, err := conn.ReadMessage()
@@ -443,6 +952,8 @@ func handleWebSocket(c *gin.Context) {
 			break
 		}
 
+		receivedAt := time.Now()
+
 		// Parse message
 		var wsMessage WebSocketMessage
 		if err := json.Unmarshal(message, &wsMessage); err != nil {
@@ -450,128 +961,218 @@ func handleWebSocket(c *gin.Context) {
 			continue
 		}
 
-		// Handle message based on type
-		switch wsMessage.Type {
-		case "authenticate":
-			// Authenticate the WebSocket connection
-			tokenData, ok := wsMessage.Data.(map[string]interface{})
-			if !ok {
-				sendError(conn, "Invalid authentication data")
-				continue
+		mu.Lock()
+
+		// Enforce limits per visitor once authenticated, per IP before
+		key := "ip:" + clientIP
+		if visitorID, ok := clients[conn]; ok {
+			key = "visitor:" + visitorID
+		}
+		if ok, _ := allowRate(wsRateLimitName(wsMessage.Type), key); !ok {
+			if receivedAt.Sub(windowStart) > time.Minute {
+				violations = 0
+				windowStart = receivedAt
 			}
+			violations++
 
-			tokenString, ok := tokenData["token"].(string)
-			if !ok {
-				sendError(conn, "Invalid token")
-				continue
+			if violations > config.RateLimitMaxViolations {
+				if err := conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Rate limit exceeded"),
+					time.Now().Add(time.Second)); err != nil {
+					log.Printf("Failed to send WebSocket close: %v", err)
+				}
+				mu.Unlock()
+				break
 			}
 
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				return []byte(config.JWTSecret), nil
-			})
+			sendError(conn, "Rate limit exceeded")
+			mu.Unlock()
+			continue
+		}
 
-			if err != nil || !token.Valid {
-				sendError(conn, "Invalid token")
-				continue
-			}
+		handleWebSocketMessage(conn, screeningID, wsMessage, receivedAt)
+		mu.Unlock()
+	}
+}
 
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				sendError(conn, "Invalid token claims")
-				continue
-			}
+// Handle a single WebSocket message based on its type. Must be called with mu held.
+func handleWebSocketMessage(conn *websocket.Conn, screeningID string, wsMessage WebSocketMessage, receivedAt time.Time) {
+	switch wsMessage.Type {
+	case "authenticate":
+		// Authenticate the WebSocket connection
+		tokenData, ok := wsMessage.Data.(map[string]interface{})
+		if !ok {
+			sendError(conn, "Invalid authentication data")
+			return
+		}
 
-			visitorID, ok := claims["sub"].(string)
-			if !ok {
-				sendError(conn, "Invalid visitor ID in token")
-				continue
+		// Operators may authenticate alongside or instead of a visitor
+		if operatorToken, ok := tokenData["operator_token"].(string); ok {
+			operator, err := parseOperatorToken(operatorToken)
+			if err != nil {
+				sendError(conn, "Invalid operator token")
+				return
 			}
+			operatorConns[conn] = operator
 
-			tokenScreeningID, ok := claims["screening_id"].(string)
-			if !ok || (tokenScreeningID != screeningID && screeningID != "default") {
-				sendError(conn, "Token not valid for this screening")
-				continue
+			if _, hasVisitorToken := tokenData["token"]; !hasVisitorToken {
+				sendJSON(conn, WebSocketMessage{
+					Type: "authenticated",
+					Data: gin.H{"success": true, "operator": operator},
+				})
+				return
 			}
+		}
 
-			// Store the WebSocket connection with the visitor ID
-			clients[conn] = visitorID
+		tokenString, ok := tokenData["token"].(string)
+		if !ok {
+			sendError(conn, "Invalid token")
+			return
+		}
 
-			// Update visitor's last active time
-			visitor := visitors[visitorID]
+		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			return []byte(config.JWTSecret), nil
+		})
+
+		if err != nil || !token.Valid {
+			sendError(conn, "Invalid token")
+			return
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			sendError(conn, "Invalid token claims")
+			return
+		}
+
+		visitorID, ok := claims["sub"].(string)
+		if !ok {
+			sendError(conn, "Invalid visitor ID in token")
+			return
+		}
+
+		tokenScreeningID, ok := claims["screening_id"].(string)
+		if !ok || (tokenScreeningID != screeningID && screeningID != "default") {
+			sendError(conn, "Token not valid for this screening")
+			return
+		}
+
+		// Get visitor
+		visitor, exists := visitors[visitorID]
+		if !exists {
+			sendError(conn, "Visitor not found")
+			return
+		}
+
+		// Private screenings need the grant issued with the token
+		if screening, exists := screenings[visitor.ScreeningID]; exists && !hasGrant(claims, screening) {
+			sendError(conn, "This screening is private")
+			return
+		}
+
+		// Queued visitors only hear about their place in line until admitted
+		if visitor.queuedFor != "" {
+			clients[conn] = visitorID
 			visitor.LastActive = time.Now()
+			sendJSON(conn, queuePositionMessage(visitor))
+			return
+		}
 
-			// Send success response
-			if err := conn.WriteJSON(WebSocketMessage{
-				Type: "authenticated",
-				Data: gin.H{"success": true},
-			}); err != nil {
-				log.Printf("Failed to send WebSocket message: %v", err)
+		// Admitted while reconnecting: hand over the lobby's token again
+		if visitor.ScreeningID != "" && tokenScreeningID != visitor.ScreeningID {
+			message, err := admissionMessage(visitor)
+			if err != nil {
+				sendError(conn, "Could not generate token")
+				return
 			}
+			sendJSON(conn, message)
+			return
+		}
 
-		case "webrtc_signal":
-			// Forward WebRTC signal to target visitor
-			if visitorID, ok := clients[conn]; ok {
-				// Get data from message
-				signalData, ok := wsMessage.Data.(map[string]interface{})
-				if !ok {
-					sendError(conn, "Invalid signal data")
-					continue
-				}
+		// Store the WebSocket connection with the visitor ID
+		clients[conn] = visitorID
 
-				targetID, ok := signalData["target"].(string)
-				if !ok {
-					sendError(conn, "Invalid target ID")
-					continue
-				}
+		// Update visitor's last active time
+		visitor.LastActive = time.Now()
 
-				// Find target connection
-				var targetConn *websocket.Conn
-				for conn, id := range clients {
-					if id == targetID {
-						targetConn = conn
-						break
-					}
-				}
+		// Send success response
+		sendJSON(conn, WebSocketMessage{
+			Type: "authenticated",
+			Data: gin.H{"success": true, "swarm_report_interval": config.SwarmReportInterval.Seconds()},
+		})
 
-				if targetConn == nil {
-					sendError(conn, "Target visitor not found")
-					continue
-				}
+		// Give late joiners the exact playback position and recent chat right away
+		if screening, exists := screenings[visitor.ScreeningID]; exists {
+			sendPlaybackSync(conn, screening)
+		}
+		sendChatHistory(conn, visitor.ScreeningID)
 
-				// Add sender ID to data
-				signalData["from"] = visitorID
+	case "webrtc_signal":
+		// Forward WebRTC signal to target visitor
+		if visitorID, ok := clients[conn]; ok {
+			handleSignal(conn, visitors[visitorID], wsMessage.Data)
+		} else {
+			sendError(conn, "Not authenticated")
+		}
 
-				// Forward message to target
-				if err := targetConn.WriteJSON(WebSocketMessage{
-					Type: "webrtc_signal",
-					Data: signalData,
-				}); err != nil {
-					log.Printf("Failed to send WebSocket message: %v", err)
-				}
-			} else {
-				sendError(conn, "Not authenticated")
-			}
+	case "chat":
+		// Store and broadcast a chat message
+		if visitorID, ok := clients[conn]; ok {
+			handleChat(conn, visitors[visitorID], wsMessage.Data)
+		} else {
+			sendError(conn, "Not authenticated")
+		}
 
-		case "heartbeat":
-			// Update visitor's last active time
-			if visitorID, ok := clients[conn]; ok {
-				visitor := visitors[visitorID]
-				visitor.LastActive = time.Now()
-			} else {
-				sendError(conn, "Not authenticated")
-			}
+	case "position_update":
+		// Store the visitor's latest transform for the position relay
+		if visitorID, ok := clients[conn]; ok {
+			handlePositionUpdate(conn, visitors[visitorID], wsMessage.Data)
+		} else {
+			sendError(conn, "Not authenticated")
+		}
+
+	case "swarm_stats":
+		// Client torrent telemetry for the operator swarm health feed
+		if visitorID, ok := clients[conn]; ok {
+			handleSwarmStats(conn, visitors[visitorID], wsMessage.Data)
+		} else {
+			sendError(conn, "Not authenticated")
+		}
+
+	case "projection_control":
+		// Operator pause, resume, seek or intermission
+		handleProjectionControl(conn, screeningID, wsMessage.Data)
+
+	case "time_sync":
+		// Answer clock synchronization probes, authenticated or not
+		handleTimeSync(conn, wsMessage.Data, receivedAt)
+
+	case "heartbeat":
+		// Update visitor's last active time
+		if visitorID, ok := clients[conn]; ok {
+			visitor := visitors[visitorID]
+			visitor.LastActive = time.Now()
+		} else {
+			sendError(conn, "Not authenticated")
 		}
 	}
 }
 
 // Send error message over WebSocket
 func sendError(conn *websocket.Conn, message string) {
-	if err := conn.WriteJSON(WebSocketMessage{
+	sendJSON(conn, WebSocketMessage{
 		Type: "error",
 		Data: gin.H{"message": message},
-	}); err != nil {
-		log.Printf("Failed to send WebSocket error message: %v", err)
+	})
+}
+
+// Decode the loosely typed data of a WebSocket message into a struct
+func decodeMessageData(data interface{}, v interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
 	}
+	return json.Unmarshal(raw, v)
 }
 
 // Verify JWT token from Authorization header
@@ -586,45 +1187,84 @@ func verifyToken(c *gin.Context) (string, error) {
 		tokenString = tokenString[7:]
 	}
 
-	// Parse the token
+	claims, err := parseVisitorClaims(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	return claims["sub"].(string), nil
+}
+
+// Parse a visitor JWT, checking that the visitor still exists. Must be called with mu held.
+func parseVisitorClaims(tokenString string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		return []byte(config.JWTSecret), nil
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("invalid token: %w", err)
+		return nil, fmt.Errorf("invalid token: %w", err)
 	}
 
 	if !token.Valid {
-		return "", fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", fmt.Errorf("invalid token claims")
+		return nil, fmt.Errorf("invalid token claims")
 	}
 
 	visitorID, ok := claims["sub"].(string)
 	if !ok {
-		return "", fmt.Errorf("invalid visitor ID in token")
+		return nil, fmt.Errorf("invalid visitor ID in token")
 	}
 
 	// Check if visitor exists
 	if _, exists := visitors[visitorID]; !exists {
-		return "", fmt.Errorf("visitor not found")
+		return nil, fmt.Errorf("visitor not found")
 	}
 
-	return visitorID, nil
+	return claims, nil
 }
 
 // Broadcast a message to all clients in a screening
 func broadcastToScreening(screeningID string, message WebSocketMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to encode WebSocket message: %v", err)
+		return
+	}
 	for conn, visitorID := range clients {
 		visitor, exists := visitors[visitorID]
 		if exists && visitor.ScreeningID == screeningID {
-			if err := conn.WriteJSON(message); err != nil {
-				log.Printf("Failed to send WebSocket message: %v", err)
-			}
+			sendRaw(conn, data)
+		}
+	}
+}
+
+// Resolve the screening in the URL, falling back to the default one. Must be called with mu held.
+func screeningParam(c *gin.Context) (string, *Screening) {
+	screeningID := c.Param("id")
+	screening, exists := screenings[screeningID]
+	if !exists {
+		return "default", screenings["default"]
+	}
+	return screeningID, screening
+}
+
+// Broadcast a screening's seats. Must be called with mu held.
+func broadcastSeats(screeningID string, screening *Screening) {
+	broadcastToScreening(screeningID, WebSocketMessage{
+		Type: "seat_update",
+		Data: screening.Seats,
+	})
+}
+
+// Send a message to every WebSocket of a single visitor. Must be called with mu held.
+func sendToVisitor(visitorID string, message WebSocketMessage) {
+	for conn, id := range clients {
+		if id == visitorID {
+			sendJSON(conn, message)
 		}
 	}
 }
@@ -634,48 +1274,75 @@ func cleanupInactiveVisitors() {
 	for {
 		time.Sleep(1 * time.Minute)
 
+		mu.Lock()
 		now := time.Now()
+		pruneChallenges(now)
 		for visitorID, visitor := range visitors {
 			// If visitor has been inactive for more than 5 minutes
 			if now.Sub(visitor.LastActive) > 5*time.Minute {
-				// If visitor has a seat, release it
-				if visitor.Seat != nil {
-					screening, exists := screenings[visitor.ScreeningID]
-					if exists {
-						for i, seat := range screening.Seats.Occupied {
-							if seat.VisitorID == visitorID {
-								screening.Seats.Occupied = append(screening.Seats.Occupied[:i], screening.Seats.Occupied[i+1:]...)
-								break
-							}
-						}
+				removeVisitor(visitorID)
+			}
+		}
+		mu.Unlock()
+	}
+}
 
-						// Broadcast seat update
-						broadcastToScreening(visitor.ScreeningID, WebSocketMessage{
-							Type: "seat_update",
-							Data: screening.Seats,
-						})
-					}
+// Remove a visitor, releasing their seat and closing their WebSockets. Must be called with mu held.
+func removeVisitor(visitorID string) {
+	visitor, exists := visitors[visitorID]
+	if !exists {
+		return
+	}
+
+	// If visitor has a seat, release it
+	if visitor.Seat != nil {
+		screening, exists := screenings[visitor.ScreeningID]
+		if exists {
+			for i, seat := range screening.Seats.Occupied {
+				if seat.VisitorID == visitorID {
+					screening.Seats.Occupied = append(screening.Seats.Occupied[:i], screening.Seats.Occupied[i+1:]...)
+					break
 				}
+			}
 
-				// Broadcast visitor left event
-				broadcastToScreening(visitor.ScreeningID, WebSocketMessage{
-					Type: "visitor_left",
-					Data: gin.H{
-						"visitor_id": visitorID,
-					},
-				})
+			// Broadcast seat update
+			broadcastToScreening(visitor.ScreeningID, WebSocketMessage{
+				Type: "seat_update",
+				Data: screening.Seats,
+			})
+		}
+	}
 
-				// Remove visitor from map
-				delete(visitors, visitorID)
+	// Drop any seat the visitor was holding while choosing
+	if screening, exists := screenings[visitor.ScreeningID]; exists && screening.Seats.removeVisitorHolds(visitorID) {
+		broadcastToScreening(visitor.ScreeningID, WebSocketMessage{
+			Type: "seat_update",
+			Data: screening.Seats,
+		})
+	}
 
-				// Close any connected WebSockets
-				for conn, id := range clients {
-					if id == visitorID {
-						conn.Close()
-						delete(clients, conn)
-					}
-				}
-			}
+	// Broadcast visitor left event
+	broadcastToScreening(visitor.ScreeningID, WebSocketMessage{
+		Type: "visitor_left",
+		Data: gin.H{
+			"visitor_id": visitorID,
+		},
+	})
+
+	// Give up their place in line, and any RSVP place
+	leaveQueue(visitor)
+	leaveRSVP(visitor)
+
+	// Remove visitor from map
+	delete(visitors, visitorID)
+	delete(swarmReports, visitorID)
+	delete(signalInboxes, visitorID)
+
+	// Close any connected WebSockets
+	for conn, id := range clients {
+		if id == visitorID {
+			conn.Close()
+			delete(clients, conn)
 		}
 	}
 }
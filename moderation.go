@@ -0,0 +1,448 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RoleModerator can delete messages, mute, kick and ban visitors
+const RoleModerator = "moderator"
+
+// Ban represents a ban of a visitor and/or their hashed IP address
+type Ban struct {
+	ID        string     `json:"id"`
+	VisitorID string     `json:"visitor_id,omitempty"`
+	IPHash    string     `json:"ip_hash,omitempty"`
+	TheaterID string     `json:"theater_id,omitempty"` // Empty for an instance-wide ban
+	Reason    string     `json:"reason,omitempty"`
+	Moderator string     `json:"moderator"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Nil for a permanent ban
+}
+
+// ModerationLogEntry represents a moderator action
+type ModerationLogEntry struct {
+	ID          int64     `json:"id"`
+	Action      string    `json:"action"`
+	Moderator   string    `json:"moderator"`
+	VisitorID   string    `json:"visitor_id,omitempty"`
+	VisitorName string    `json:"visitor_name,omitempty"`
+	IPHash      string    `json:"ip_hash,omitempty"`
+	ScreeningID string    `json:"screening_id,omitempty"`
+	TheaterID   string    `json:"theater_id,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Details     gin.H     `json:"details,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// kickCooldown keeps a kicked address out of a theater for a while
+type kickCooldown struct {
+	TheaterID string
+	Until     time.Time
+}
+
+var (
+	bans          = make(map[string]*Ban)
+	kickedIPs     = make(map[string]kickCooldown) // IP hash -> when it may rejoin
+	mutedIPs      = make(map[string]time.Time)    // IP hash -> end of its mute
+	moderationLog []ModerationLogEntry
+	nextLogID     int64
+	wordFilter    *regexp.Regexp // Nil when no words are filtered
+)
+
+// Compile the configured word list into a case-insensitive whole-word matcher
+func compileWordFilter(words []string) *regexp.Regexp {
+	var quoted []string
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// Mask filtered words with asterisks
+func filterWords(text string) string {
+	if wordFilter == nil {
+		return text
+	}
+	return wordFilter.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", len([]rune(word)))
+	})
+}
+
+// Check whether text contains a filtered word
+func containsFilteredWord(text string) bool {
+	return wordFilter != nil && wordFilter.MatchString(text)
+}
+
+// Hash a client IP so bans never store raw addresses
+func hashIP(ip string) string {
+	mac := hmac.New(sha256.New, []byte(config.JWTSecret))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Get the theater a screening belongs to, falling back to the default screening's
+func theaterOf(screeningID string) string {
+	if screening, exists := screenings[screeningID]; exists {
+		return screening.TheaterID
+	}
+	return screenings["default"].TheaterID
+}
+
+// Whether a ban covers a visitor ID or IP hash in a theater, ignoring expiry
+func (ban *Ban) matches(visitorID, ipHash, theaterID string) bool {
+	if ban.TheaterID != "" && ban.TheaterID != theaterID {
+		return false
+	}
+	return (ban.VisitorID != "" && ban.VisitorID == visitorID) || (ban.IPHash != "" && ban.IPHash == ipHash)
+}
+
+// Find an active ban matching a visitor ID or IP hash in a theater. Must be called with mu held.
+func findBan(visitorID, ipHash, theaterID string) *Ban {
+	now := time.Now()
+	for id, ban := range bans {
+		if ban.ExpiresAt != nil && now.After(*ban.ExpiresAt) {
+			delete(bans, id)
+			continue
+		}
+		if ban.matches(visitorID, ipHash, theaterID) {
+			return ban
+		}
+	}
+	return nil
+}
+
+// When a kicked IP hash may join a theater again, or zero if it may now.
+// Must be called with mu held.
+func kickedUntil(ipHash, theaterID string) time.Time {
+	kick, exists := kickedIPs[ipHash]
+	if !exists {
+		return time.Time{}
+	}
+	if time.Now().After(kick.Until) {
+		delete(kickedIPs, ipHash)
+		return time.Time{}
+	}
+	if kick.TheaterID != theaterID {
+		return time.Time{}
+	}
+	return kick.Until
+}
+
+// When a visitor's mute ends, counting mutes of their address so a new
+// token doesn't lift one. Must be called with mu held.
+func mutedUntil(visitor *Visitor) time.Time {
+	until := visitor.MutedUntil
+	if ipUntil, exists := mutedIPs[visitor.IPHash]; exists {
+		if time.Now().After(ipUntil) {
+			delete(mutedIPs, visitor.IPHash)
+		} else if ipUntil.After(until) {
+			until = ipUntil
+		}
+	}
+	return until
+}
+
+// Append an entry to the bounded moderation log. Must be called with mu held.
+func logModeration(entry ModerationLogEntry) {
+	nextLogID++
+	entry.ID = nextLogID
+	entry.Timestamp = time.Now()
+
+	moderationLog = append(moderationLog, entry)
+	if len(moderationLog) > config.ModerationLogSize {
+		moderationLog = moderationLog[len(moderationLog)-config.ModerationLogSize:]
+	}
+}
+
+// Build a log entry describing a visitor
+func visitorLogEntry(action string, operator *Operator, visitor *Visitor, reason string) ModerationLogEntry {
+	return ModerationLogEntry{
+		Action:      action,
+		Moderator:   operator.Name,
+		VisitorID:   visitor.ID,
+		VisitorName: visitor.Name,
+		IPHash:      visitor.IPHash,
+		ScreeningID: visitor.ScreeningID,
+		TheaterID:   theaterOf(visitor.ScreeningID),
+		Reason:      reason,
+	}
+}
+
+// Tell a visitor why they are being removed and remove them. Must be called with mu held.
+func kickVisitorWithReason(visitorID, action, reason string) {
+	sendToVisitor(visitorID, WebSocketMessage{
+		Type: action,
+		Data: gin.H{"reason": reason},
+	})
+	removeVisitor(visitorID)
+}
+
+// Delete a chat message
+func deleteChatMessage(c *gin.Context) {
+	messageID, err := strconv.ParseInt(c.Param("message_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	screeningID := c.Param("id")
+	history := chatHistory[screeningID]
+	for i, message := range history {
+		if message.ID != messageID {
+			continue
+		}
+
+		chatHistory[screeningID] = append(history[:i:i], history[i+1:]...)
+
+		logModeration(ModerationLogEntry{
+			Action:      "delete_message",
+			Moderator:   currentOperator(c).Name,
+			VisitorID:   message.VisitorID,
+			VisitorName: message.VisitorName,
+			ScreeningID: screeningID,
+			TheaterID:   theaterOf(screeningID),
+			Details:     gin.H{"message_id": message.ID, "text": message.Text},
+		})
+
+		broadcastToScreening(screeningID, WebSocketMessage{
+			Type: "chat_deleted",
+			Data: gin.H{"message_id": message.ID},
+		})
+
+		c.JSON(http.StatusOK, gin.H{"success": true})
+		return
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+}
+
+// Mute a visitor for a number of minutes
+func muteVisitor(c *gin.Context) {
+	var request struct {
+		Minutes int    `json:"minutes" binding:"required"`
+		Reason  string `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil || request.Minutes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	visitor, exists := visitors[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Visitor not found"})
+		return
+	}
+
+	visitor.MutedUntil = time.Now().Add(time.Duration(request.Minutes) * time.Minute)
+	if visitor.MutedUntil.After(mutedIPs[visitor.IPHash]) {
+		mutedIPs[visitor.IPHash] = visitor.MutedUntil
+	}
+
+	entry := visitorLogEntry("mute", currentOperator(c), visitor, request.Reason)
+	entry.Details = gin.H{"until": visitor.MutedUntil}
+	logModeration(entry)
+
+	sendToVisitor(visitor.ID, WebSocketMessage{
+		Type: "muted",
+		Data: gin.H{"until": visitor.MutedUntil, "reason": request.Reason},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"muted_until": visitor.MutedUntil,
+	})
+}
+
+// Kick a visitor from their screening and keep their address out of the
+// theater for a while
+func kickVisitor(c *gin.Context) {
+	var request struct {
+		Reason  string `json:"reason"`
+		Minutes *int   `json:"minutes"` // Defaults to KICK_COOLDOWN
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil || (request.Minutes != nil && *request.Minutes < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	visitor, exists := visitors[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Visitor not found"})
+		return
+	}
+
+	cooldown := config.KickCooldown
+	if request.Minutes != nil {
+		cooldown = time.Duration(*request.Minutes) * time.Minute
+	}
+
+	entry := visitorLogEntry("kick", currentOperator(c), visitor, request.Reason)
+	response := gin.H{"success": true}
+	if cooldown > 0 {
+		until := time.Now().Add(cooldown)
+		kickedIPs[visitor.IPHash] = kickCooldown{TheaterID: entry.TheaterID, Until: until}
+		entry.Details = gin.H{"until": until}
+		response["kicked_until"] = until
+	}
+	logModeration(entry)
+	kickVisitorWithReason(visitor.ID, "kicked", request.Reason)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Ban a visitor and their hashed IP from a theater or the whole instance
+func createBan(c *gin.Context) {
+	var request struct {
+		VisitorID       string `json:"visitor_id"`
+		IPHash          string `json:"ip_hash"`
+		Scope           string `json:"scope"` // "theater" (default) or "instance"
+		TheaterID       string `json:"theater_id"`
+		Reason          string `json:"reason"`
+		DurationMinutes int    `json:"duration_minutes"` // 0 for a permanent ban
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil ||
+		(request.VisitorID == "" && request.IPHash == "") || request.DurationMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if request.Scope != "" && request.Scope != "theater" && request.Scope != "instance" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scope must be 'theater' or 'instance'"})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	ban := &Ban{
+		ID:        uuid.New().String(),
+		VisitorID: request.VisitorID,
+		IPHash:    request.IPHash,
+		Reason:    request.Reason,
+		Moderator: currentOperator(c).Name,
+		CreatedAt: time.Now(),
+	}
+	if request.DurationMinutes > 0 {
+		expiresAt := ban.CreatedAt.Add(time.Duration(request.DurationMinutes) * time.Minute)
+		ban.ExpiresAt = &expiresAt
+	}
+
+	// Fill in the IP hash and theater from the visitor if they are still around
+	if visitor, exists := visitors[request.VisitorID]; exists {
+		if ban.IPHash == "" {
+			ban.IPHash = visitor.IPHash
+		}
+		if request.TheaterID == "" {
+			request.TheaterID = theaterOf(visitor.ScreeningID)
+		}
+	}
+	if request.Scope != "instance" {
+		if request.TheaterID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Theater ID is required for a theater ban"})
+			return
+		}
+		ban.TheaterID = request.TheaterID
+	}
+
+	bans[ban.ID] = ban
+
+	logModeration(ModerationLogEntry{
+		Action:    "ban",
+		Moderator: ban.Moderator,
+		VisitorID: ban.VisitorID,
+		IPHash:    ban.IPHash,
+		TheaterID: ban.TheaterID,
+		Reason:    ban.Reason,
+		Details:   gin.H{"ban_id": ban.ID, "expires_at": ban.ExpiresAt},
+	})
+
+	// Remove everyone the ban covers right away
+	for visitorID, visitor := range visitors {
+		if ban.matches(visitorID, visitor.IPHash, theaterOf(visitor.ScreeningID)) {
+			kickVisitorWithReason(visitorID, "banned", ban.Reason)
+		}
+	}
+
+	c.JSON(http.StatusOK, ban)
+}
+
+// List active bans
+func listBans(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	result := []*Ban{}
+	for _, ban := range bans {
+		if ban.ExpiresAt == nil || now.Before(*ban.ExpiresAt) {
+			result = append(result, ban)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"bans": result})
+}
+
+// Lift a ban
+func deleteBan(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	ban, exists := bans[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ban not found"})
+		return
+	}
+
+	delete(bans, ban.ID)
+
+	logModeration(ModerationLogEntry{
+		Action:    "unban",
+		Moderator: currentOperator(c).Name,
+		VisitorID: ban.VisitorID,
+		IPHash:    ban.IPHash,
+		TheaterID: ban.TheaterID,
+		Details:   gin.H{"ban_id": ban.ID},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Get the moderation log, newest first
+func getModerationLog(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	entries := make([]ModerationLogEntry, 0, len(moderationLog))
+	for i := len(moderationLog) - 1; i >= 0; i-- {
+		entries = append(entries, moderationLog[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// How long a stream ticket can wait before being used
+const streamTicketTTL = 30 * time.Second
+
+// streamTicket lets one event stream authenticate without a header. It is
+// used once, so it is useless by the time it reaches an access log.
+type streamTicket struct {
+	operator  *Operator
+	expiresAt time.Time
+}
+
+var streamTickets = make(map[string]*streamTicket) // Ticket -> operator it stands for; guarded by mu
+
+// Operator roles
+const (
+	RoleAdmin         = "admin"
+	RoleProjectionist = "projectionist"
+)
+
+// OperatorAccount represents an operator who can manage the instance
+type OperatorAccount struct {
+	Name  string   `json:"name"`
+	Key   string   `json:"-"`
+	Roles []string `json:"roles"`
+}
+
+// Operator represents an authenticated operator taken from a token
+type Operator struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+// Check whether the operator has a role; admins have every role
+func (o *Operator) hasRole(role string) bool {
+	for _, r := range o.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse operator accounts from "name:key:role,role;name:key:role"
+func parseOperators(value string) []OperatorAccount {
+	var accounts []OperatorAccount
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			log.Printf("Ignoring malformed operator entry %q", entry)
+			continue
+		}
+		accounts = append(accounts, OperatorAccount{
+			Name:  parts[0],
+			Key:   parts[1],
+			Roles: strings.Split(parts[2], ","),
+		})
+	}
+	return accounts
+}
+
+// Create an operator token
+func createOperatorToken(c *gin.Context) {
+	var request struct {
+		Name string `json:"name" binding:"required"`
+		Key  string `json:"key" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var account *OperatorAccount
+	for i := range config.Operators {
+		candidate := &config.Operators[i]
+		if candidate.Name == request.Name &&
+			subtle.ConstantTimeCompare([]byte(candidate.Key), []byte(request.Key)) == 1 {
+			account = candidate
+			break
+		}
+	}
+	if account == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid operator credentials"})
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   "operator:" + account.Name,
+		"name":  account.Name,
+		"kind":  "operator",
+		"roles": account.Roles,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(12 * time.Hour).Unix(),
+	})
+
+	tokenString, err := token.SignedString([]byte(config.JWTSecret))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":    tokenString,
+		"operator": Operator{Name: account.Name, Roles: account.Roles},
+	})
+}
+
+// Parse and validate an operator token
+func parseOperatorToken(tokenString string) (*Operator, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.JWTSecret), nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["kind"] != "operator" {
+		return nil, fmt.Errorf("not an operator token")
+	}
+
+	name, ok := claims["name"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid operator name in token")
+	}
+
+	operator := &Operator{Name: name}
+	if roles, ok := claims["roles"].([]interface{}); ok {
+		for _, role := range roles {
+			if r, ok := role.(string); ok {
+				operator.Roles = append(operator.Roles, r)
+			}
+		}
+	}
+
+	return operator, nil
+}
+
+// Issue a stream ticket for the calling operator
+func createStreamTicket(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for ticket, issued := range streamTickets {
+		if now.After(issued.expiresAt) {
+			delete(streamTickets, ticket)
+		}
+	}
+
+	ticket := uuid.New().String()
+	expiresAt := now.Add(streamTicketTTL)
+	streamTickets[ticket] = &streamTicket{operator: currentOperator(c), expiresAt: expiresAt}
+
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_at": expiresAt})
+}
+
+// Use up a stream ticket, returning the operator it was issued to
+func redeemStreamTicket(ticket string) (*Operator, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	issued, exists := streamTickets[ticket]
+	if !exists {
+		return nil, fmt.Errorf("invalid stream ticket")
+	}
+	delete(streamTickets, ticket)
+	if time.Now().After(issued.expiresAt) {
+		return nil, fmt.Errorf("stream ticket expired")
+	}
+	return issued.operator, nil
+}
+
+// Require an operator token carrying the given role
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+		var operator *Operator
+		var err error
+		if tokenString == "" && c.GetHeader("Accept") == "text/event-stream" && c.Query("ticket") != "" {
+			// EventSource can't set headers, so event streams pass a stream ticket instead
+			operator, err = redeemStreamTicket(c.Query("ticket"))
+		} else {
+			operator, err = parseOperatorToken(tokenString)
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		if !operator.hasRole(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing role " + role})
+			return
+		}
+
+		c.Set("operator", operator)
+		c.Next()
+	}
+}
+
+// Get the operator set by requireRole
+func currentOperator(c *gin.Context) *Operator {
+	return c.MustGet("operator").(*Operator)
+}
+
+// Send a message to every operator WebSocket with a role. Must be called with mu held.
+func sendToOperators(role string, message WebSocketMessage) {
+	for conn, operator := range operatorConns {
+		if operator.hasRole(role) {
+			sendJSON(conn, message)
+		}
+	}
+}
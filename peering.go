@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// How far apart two peers are for matching purposes; lower is better. Peers
+// in the same lobby come first, nearest seats before farther ones, then peers
+// in other lobbies of the same showing, then everyone else. Placements are
+// as of each peer's latest announce.
+func peerDistance(a, b *trackerPeer) int {
+	if a.place.screeningID == "" || b.place.screeningID == "" {
+		return 3000
+	}
+	if a.place.scheduleID != b.place.scheduleID {
+		return 2000
+	}
+	if a.place.screeningID != b.place.screeningID {
+		return 1000
+	}
+
+	// Same lobby: seated neighbours share the same playback position
+	seatA, seatB := a.place.seat, b.place.seat
+	if seatA == nil || seatB == nil {
+		return 500
+	}
+	return abs(seatA.Row-seatB.Row)*10 + abs(seatA.Seat-seatB.Seat)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Choose up to count peers to pass a peer's offers to. Peers already matched
+// with it or at the connection cap are skipped, and a peer with no partners
+// yet gets one that already has the early pieces first so playback can start.
+// Must be called with trackerMu held.
+func choosePeers(swarm map[string]*trackerPeer, peerID string, count int) []*trackerPeer {
+	self := swarm[peerID]
+	if room := config.TrackerMaxConnections - len(self.connected); room < count {
+		count = room
+	}
+	if count <= 0 {
+		return nil
+	}
+
+	candidates := make([]*trackerPeer, 0, len(swarm))
+	for otherID, other := range swarm {
+		if otherID == peerID || self.connected[otherID] || len(other.connected) >= config.TrackerMaxConnections {
+			continue
+		}
+		candidates = append(candidates, other)
+	}
+
+	// Shuffle first so equally good peers share the load
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	distances := make(map[*trackerPeer]int, len(candidates))
+	for _, candidate := range candidates {
+		distances[candidate] = peerDistance(self, candidate)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return distances[candidates[i]] < distances[candidates[j]]
+	})
+
+	if len(self.connected) == 0 && len(candidates) > 0 && !candidates[0].hasData {
+		for i, candidate := range candidates {
+			if candidate.hasData {
+				copy(candidates[1:i+1], candidates[:i])
+				candidates[0] = candidate
+				break
+			}
+		}
+	}
+
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+	return candidates
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Playback represents the authoritative playback clock of a screening.
+// While playing, the film position is Offset plus the time elapsed since
+// AnchorTime; while paused it is Offset.
+type Playback struct {
+	Paused     bool          `json:"paused"`
+	Offset     time.Duration `json:"-"`
+	AnchorTime time.Time     `json:"-"`
+	PausedAt   time.Time     `json:"-"`
+
+	// Set while an operator-inserted intermission is running
+	IntermissionUntil   time.Time `json:"-"`
+	IntermissionMessage string    `json:"-"`
+}
+
+// Create a playback clock that starts playing from the beginning at startTime
+func newPlayback(startTime time.Time) *Playback {
+	return &Playback{AnchorTime: startTime}
+}
+
+// Film position at the given time; negative before the screening starts
+func (p *Playback) position(now time.Time) time.Duration {
+	if p.Paused {
+		return p.Offset
+	}
+	return p.Offset + now.Sub(p.AnchorTime)
+}
+
+// Include the current position so REST responses can seek late joiners too
+func (p *Playback) MarshalJSON() ([]byte, error) {
+	now := time.Now()
+	return json.Marshal(p.state(now))
+}
+
+// Snapshot of the clock as sent to clients
+func (p *Playback) state(now time.Time) gin.H {
+	state := gin.H{
+		"paused":      p.Paused,
+		"position":    p.position(now).Seconds(),
+		"server_time": now.UnixMilli(),
+	}
+	if !p.IntermissionUntil.IsZero() {
+		state["intermission"] = gin.H{
+			"until":   p.IntermissionUntil,
+			"message": p.IntermissionMessage,
+		}
+	}
+	return state
+}
+
+// Build the playback_sync message for a screening
+func playbackSyncMessage(screening *Screening) WebSocketMessage {
+	data := screening.Playback.state(time.Now())
+	data["screening_id"] = screening.ID
+	data["start_time"] = screening.StartTime
+	return WebSocketMessage{
+		Type: "playback_sync",
+		Data: data,
+	}
+}
+
+// Send the current playback state to a single connection. Must be called with mu held.
+func sendPlaybackSync(conn *websocket.Conn, screening *Screening) {
+	sendJSON(conn, playbackSyncMessage(screening))
+}
+
+// Handle a time_sync probe. The client sends its own clock reading and gets
+// back the server's receive and transmit times, from which it computes the
+// round-trip time and clock offset the same way NTP does.
+func handleTimeSync(conn *websocket.Conn, data interface{}, receivedAt time.Time) {
+	var request struct {
+		ClientTime int64 `json:"client_time"`
+	}
+	if err := decodeMessageData(data, &request); err != nil {
+		sendError(conn, "Invalid time sync data")
+		return
+	}
+
+	sendJSON(conn, WebSocketMessage{
+		Type: "time_sync",
+		Data: gin.H{
+			"client_time":   request.ClientTime,
+			"receive_time":  receivedAt.UnixMilli(),
+			"transmit_time": time.Now().UnixMilli(),
+		},
+	})
+}
+
+// Periodically send every screening's playback state to its visitors
+func broadcastPlaybackSync() {
+	ticker := time.NewTicker(config.PlaybackSyncInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		mu.Lock()
+		resumeFinishedIntermissions(now)
+		for screeningID, screening := range screenings {
+			broadcastToScreening(screeningID, playbackSyncMessage(screening))
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Vector3 represents a position or Euler rotation in the 3D world
+type Vector3 struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// Transform represents a visitor's latest position and rotation
+type Transform struct {
+	Position Vector3 `json:"position"`
+	Rotation Vector3 `json:"rotation"`
+	Room     string  `json:"room,omitempty"`
+}
+
+// PositionEntry represents one visitor's transform in a position batch
+type PositionEntry struct {
+	VisitorID string `json:"visitor_id"`
+	Transform
+}
+
+// Check that every component is a finite number
+func (v Vector3) valid() bool {
+	for _, f := range []float64{v.X, v.Y, v.Z} {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Distance between two positions
+func (v Vector3) distance(other Vector3) float64 {
+	dx, dy, dz := v.X-other.X, v.Y-other.Y, v.Z-other.Z
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// Handle a position_update message. Must be called with mu held.
+func handlePositionUpdate(conn *websocket.Conn, visitor *Visitor, data interface{}) {
+	var transform Transform
+	if err := decodeMessageData(data, &transform); err != nil ||
+		!transform.Position.valid() || !transform.Rotation.valid() {
+		sendError(conn, "Invalid position data")
+		return
+	}
+
+	// Silently drop updates that arrive faster than the configured rate
+	now := time.Now()
+	if now.Sub(visitor.lastPositionAt) < config.PositionMinInterval {
+		return
+	}
+
+	visitor.Transform = &transform
+	visitor.positionDirty = true
+	visitor.lastPositionAt = now
+	visitor.LastActive = now
+}
+
+// Check whether a visitor's transform is relevant to a recipient
+func withinInterest(recipient, other *Visitor) bool {
+	if recipient.Transform == nil || other.Transform == nil {
+		return false
+	}
+	if recipient.Transform.Room != other.Transform.Room {
+		return false
+	}
+	if config.PositionRadius <= 0 {
+		return true
+	}
+	return recipient.Transform.Position.distance(other.Transform.Position) <= config.PositionRadius
+}
+
+// Relay batched position updates to each connected visitor every tick
+func broadcastPositions() {
+	ticker := time.NewTicker(config.PositionTick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		mu.Lock()
+		relayPositions()
+		mu.Unlock()
+	}
+}
+
+// Send each connected visitor the transforms of nearby visitors that changed
+// since the last tick, plus anyone who entered or left their interest area.
+// Must be called with mu held.
+func relayPositions() {
+	// Group connected visitors by screening
+	connected := make(map[string][]*Visitor)
+	conns := make(map[string]*websocket.Conn)
+	for conn, visitorID := range clients {
+		visitor, exists := visitors[visitorID]
+		if !exists {
+			continue
+		}
+		connected[visitor.ScreeningID] = append(connected[visitor.ScreeningID], visitor)
+		conns[visitorID] = conn
+	}
+
+	serverTime := time.Now().UnixMilli()
+	for _, group := range connected {
+		for _, recipient := range group {
+			var updates []PositionEntry
+			var removed []string
+
+			inRange := make(map[string]bool)
+			for _, other := range group {
+				if other.ID == recipient.ID || !withinInterest(recipient, other) {
+					continue
+				}
+				inRange[other.ID] = true
+
+				// Send transforms that changed, or that the recipient hasn't seen yet
+				if other.positionDirty || !recipient.inRange[other.ID] {
+					updates = append(updates, PositionEntry{VisitorID: other.ID, Transform: *other.Transform})
+				}
+			}
+			for visitorID := range recipient.inRange {
+				if !inRange[visitorID] {
+					removed = append(removed, visitorID)
+				}
+			}
+			recipient.inRange = inRange
+
+			if len(updates) == 0 && len(removed) == 0 {
+				continue
+			}
+
+			sendJSON(conns[recipient.ID], WebSocketMessage{
+				Type: "position_batch",
+				Data: gin.H{
+					"updates":     updates,
+					"removed":     removed,
+					"server_time": serverTime,
+				},
+			})
+		}
+	}
+
+	for _, group := range connected {
+		for _, visitor := range group {
+			visitor.positionDirty = false
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Readiness statuses of an upcoming screening's swarm
+const (
+	ReadinessReady   = "ready"    // Seeds on the tracker
+	ReadinessWebSeed = "web_seed" // No seeds, but a web seed can serve the film until some arrive
+	ReadinessCold    = "cold"     // Nobody to get the film from yet
+	ReadinessUnknown = "unknown"  // Magnet link without a usable info hash
+)
+
+// Web seed states while warming
+const (
+	WebSeedNone      = "none"
+	WebSeedAvailable = "available"
+	WebSeedWarming   = "warming"
+	WebSeedWarm      = "warm"
+	WebSeedMissing   = "missing"
+)
+
+// How much of each media file is read to warm the disk cache; enough for
+// the first minutes of playback and the metadata at the front of most files
+const prewarmBytes = 64 << 20
+
+// Readiness describes whether an upcoming screening's first viewers will
+// find someone to download from
+type Readiness struct {
+	ScreeningID string    `json:"screening_id"`
+	ScheduleID  string    `json:"schedule_id"`
+	Title       string    `json:"title"`
+	StartTime   time.Time `json:"start_time"`
+	InfoHash    string    `json:"info_hash,omitempty"`
+	Status      string    `json:"status"`
+	Seeds       int       `json:"seeds"`    // Complete peers on the embedded tracker
+	Leechers    int       `json:"leechers"` // Incomplete peers on the embedded tracker
+	WebSeed     string    `json:"web_seed"`
+	Metadata    bool      `json:"metadata"` // A .torrent is served, so metadata doesn't wait for peers
+	Warned      bool      `json:"warned"`
+}
+
+// What the warmer did for a schedule
+type prewarmState struct {
+	warned  bool
+	webSeed string // Set once warming of the film's web seed started
+}
+
+var prewarmStates = make(map[string]*prewarmState) // Schedule ID -> state
+
+// Key of an info hash in the tracker's swarms, which WebTorrent announces
+// as a binary string of 20 code points
+func trackerInfoHash(hexHash string) string {
+	raw, err := hex.DecodeString(hexHash)
+	if err != nil {
+		return ""
+	}
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// Work out a screening's readiness. Must be called with mu held.
+func screeningReadiness(screening *Screening) *Readiness {
+	readiness := &Readiness{
+		ScreeningID: screening.ID,
+		ScheduleID:  screening.ScheduleID,
+		Title:       screening.Title,
+		StartTime:   screening.StartTime,
+		Status:      ReadinessUnknown,
+		WebSeed:     WebSeedNone,
+	}
+
+	film := films[screening.FilmID]
+	if film != nil && film.InfoHash != "" {
+		readiness.InfoHash = film.InfoHash
+	} else if magnet, err := parseMagnet(screening.MagnetLink); err == nil {
+		readiness.InfoHash = magnet.InfoHash
+	}
+	if readiness.InfoHash == "" {
+		return readiness
+	}
+
+	trackerMu.Lock()
+	readiness.Seeds, readiness.Leechers = swarmCounts(trackerInfoHash(readiness.InfoHash))
+	trackerMu.Unlock()
+	if film != nil {
+		readiness.Metadata = film.TorrentFile != ""
+		if film.MediaPath != "" {
+			readiness.WebSeed = WebSeedMissing
+			if full, err := mediaFile(film.MediaPath); err == nil {
+				if _, err := os.Stat(full); err == nil {
+					readiness.WebSeed = WebSeedAvailable
+				}
+			}
+		}
+	}
+
+	state := prewarmStates[screening.ScheduleID]
+	if state != nil {
+		readiness.Warned = state.warned
+		if state.webSeed != "" && readiness.WebSeed == WebSeedAvailable {
+			readiness.WebSeed = state.webSeed
+		}
+	}
+
+	switch {
+	case readiness.Seeds > 0:
+		readiness.Status = ReadinessReady
+	case readiness.WebSeed == WebSeedAvailable || readiness.WebSeed == WebSeedWarming || readiness.WebSeed == WebSeedWarm:
+		readiness.Status = ReadinessWebSeed
+	default:
+		readiness.Status = ReadinessCold
+	}
+	return readiness
+}
+
+// Read the start of every file of a media library entry so the first web
+// seed requests are served from the page cache
+func warmWebSeed(relative string) error {
+	root, err := mediaFile(relative)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(root, func(full string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		file, err := os.Open(full)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.CopyN(io.Discard, file, prewarmBytes)
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	})
+}
+
+// Check screenings starting within the warm-up lead: warn operators once
+// when a film's swarm has no seeds and, if enabled, warm its local web seed.
+// Must be called with mu held.
+func checkUpcomingScreenings(now time.Time) {
+	for _, screening := range screenings {
+		if screening.Overflow || !screening.StartTime.After(now) || screening.StartTime.Sub(now) > config.PrewarmLead {
+			continue
+		}
+
+		state, exists := prewarmStates[screening.ScheduleID]
+		if !exists {
+			state = &prewarmState{}
+			prewarmStates[screening.ScheduleID] = state
+		}
+
+		readiness := screeningReadiness(screening)
+		if readiness.Seeds > 0 || readiness.Status == ReadinessUnknown {
+			continue
+		}
+
+		if config.PrewarmWebSeed && readiness.WebSeed == WebSeedAvailable && state.webSeed == "" {
+			film := films[screening.FilmID]
+			state.webSeed = WebSeedWarming
+			go func(scheduleID, mediaPath string) {
+				err := warmWebSeed(mediaPath)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					log.Printf("Could not warm web seed %s for schedule %s: %v", mediaPath, scheduleID, err)
+					state.webSeed = WebSeedMissing
+					return
+				}
+				state.webSeed = WebSeedWarm
+			}(screening.ScheduleID, film.MediaPath)
+		}
+
+		if !state.warned {
+			state.warned = true
+			readiness.Warned = true
+			gap := "no seeds or web seed"
+			if readiness.Status == ReadinessWebSeed {
+				gap = "no seeds; its web seed can serve viewers until some arrive"
+			}
+			log.Printf("Screening %s (%s) starts at %s with %s", screening.ID, screening.Title,
+				screening.StartTime.Format(time.RFC3339), gap)
+			sendToOperators(RoleProjectionist, WebSocketMessage{
+				Type: "readiness_warning",
+				Data: readiness,
+			})
+		}
+	}
+
+	// Forget schedules that have started
+	for scheduleID := range prewarmStates {
+		if lobbies := scheduleLobbies(scheduleID, now); len(lobbies) == 0 || !lobbies[0].StartTime.After(now) {
+			delete(prewarmStates, scheduleID)
+		}
+	}
+}
+
+// Check upcoming screenings periodically
+func prewarmScreenings() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		mu.Lock()
+		checkUpcomingScreenings(now)
+		mu.Unlock()
+	}
+}
+
+// List the readiness of screenings starting within a window, soonest first
+func getReadiness(c *gin.Context) {
+	window := 24 * time.Hour
+	if value := c.Query("within"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window"})
+			return
+		}
+		window = parsed
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	result := []*Readiness{}
+	for _, screening := range screenings {
+		if screening.Overflow || !screening.StartTime.After(now) || screening.StartTime.Sub(now) > window {
+			continue
+		}
+		result = append(result, screeningReadiness(screening))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"lead": config.PrewarmLead.String(), "screenings": result})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ProjectionCommand represents a live projection control issued by an operator
+type ProjectionCommand struct {
+	Action   string  `json:"action"`             // pause, resume, seek or intermission
+	Position float64 `json:"position,omitempty"` // Seconds into the film, for seek
+	Duration float64 `json:"duration,omitempty"` // Seconds, for intermission
+	Message  string  `json:"message,omitempty"`  // Shown to visitors during an intermission
+}
+
+// Check that a command is well formed before applying it anywhere
+func (cmd ProjectionCommand) validate() error {
+	switch cmd.Action {
+	case "pause", "resume":
+		return nil
+	case "seek":
+		if cmd.Position < 0 {
+			return fmt.Errorf("position must not be negative")
+		}
+		return nil
+	case "intermission":
+		if cmd.Duration <= 0 {
+			return fmt.Errorf("duration must be greater than 0")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q", cmd.Action)
+	}
+}
+
+// Pause the clock, remembering when so the end time can be pushed back on resume
+func (p *Playback) pause(now time.Time) {
+	if p.Paused {
+		return
+	}
+	p.Offset = p.position(now)
+	p.Paused = true
+	p.PausedAt = now
+}
+
+// Resume the clock and return how long it was paused
+func (p *Playback) resume(now time.Time) time.Duration {
+	p.IntermissionUntil = time.Time{}
+	p.IntermissionMessage = ""
+	if !p.Paused {
+		return 0
+	}
+	p.Paused = false
+	p.AnchorTime = now
+	return now.Sub(p.PausedAt)
+}
+
+// Jump to a position, keeping the current paused or playing state
+func (p *Playback) seek(position time.Duration, now time.Time) {
+	p.Offset = position
+	p.AnchorTime = now
+}
+
+// Apply a projection command to one screening. Must be called with mu held.
+func (s *Screening) applyProjection(cmd ProjectionCommand, now time.Time) {
+	switch cmd.Action {
+	case "pause":
+		s.Playback.pause(now)
+	case "resume":
+		// Hold the show rather than cut it short
+		s.EndTime = s.EndTime.Add(s.Playback.resume(now))
+	case "seek":
+		s.Playback.seek(time.Duration(cmd.Position*float64(time.Second)), now)
+	case "intermission":
+		s.Playback.pause(now)
+		s.Playback.IntermissionUntil = now.Add(time.Duration(cmd.Duration * float64(time.Second)))
+		s.Playback.IntermissionMessage = cmd.Message
+	}
+}
+
+// Apply a projection command to every lobby showing the same schedule as the
+// given screening and notify their visitors. Returns the number of lobbies
+// affected. Must be called with mu held.
+func projectSchedule(screening *Screening, cmd ProjectionCommand, operatorName string) int {
+	now := time.Now()
+	affected := 0
+	for screeningID, lobby := range screenings {
+		if lobby.ScheduleID != screening.ScheduleID {
+			continue
+		}
+		lobby.applyProjection(cmd, now)
+		affected++
+
+		broadcastToScreening(screeningID, WebSocketMessage{
+			Type: "projection_event",
+			Data: gin.H{
+				"action":             cmd.Action,
+				"operator":           operatorName,
+				"message":            lobby.Playback.IntermissionMessage,
+				"intermission_until": lobby.Playback.IntermissionUntil,
+				"timestamp":          now,
+			},
+		})
+		broadcastToScreening(screeningID, playbackSyncMessage(lobby))
+	}
+	return affected
+}
+
+// End intermissions whose time is up. Must be called with mu held.
+func resumeFinishedIntermissions(now time.Time) {
+	for _, screening := range screenings {
+		playback := screening.Playback
+		if playback.Paused && !playback.IntermissionUntil.IsZero() && now.After(playback.IntermissionUntil) {
+			projectSchedule(screening, ProjectionCommand{Action: "resume"}, "intermission")
+		}
+	}
+}
+
+// Control projection of a screening over REST
+func controlProjection(c *gin.Context) {
+	var cmd ProjectionCommand
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := cmd.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	screening, exists := screenings[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Screening not found"})
+		return
+	}
+
+	affected := projectSchedule(screening, cmd, currentOperator(c).Name)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"lobbies":  affected,
+		"playback": screening.Playback,
+	})
+}
+
+// Control projection of the connection's screening over WebSocket. Must be called with mu held.
+func handleProjectionControl(conn *websocket.Conn, screeningID string, data interface{}) {
+	// Projectionists control any screening; visitors only the room they host
+	var issuer string
+	if operator, ok := operatorConns[conn]; ok && operator.hasRole(RoleProjectionist) {
+		issuer = operator.Name
+	} else if visitorID, ok := clients[conn]; ok && isRoomHost(screeningID, visitorID) {
+		issuer = visitors[visitorID].Name
+	} else {
+		sendError(conn, "Projectionist role required")
+		return
+	}
+
+	var cmd ProjectionCommand
+	if err := decodeMessageData(data, &cmd); err != nil {
+		sendError(conn, "Invalid projection data")
+		return
+	}
+
+	if err := cmd.validate(); err != nil {
+		sendError(conn, err.Error())
+		return
+	}
+
+	screening, exists := screenings[screeningID]
+	if !exists {
+		sendError(conn, "Screening not found")
+		return
+	}
+
+	projectSchedule(screening, cmd, issuer)
+}
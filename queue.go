@@ -0,0 +1,232 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Visitors waiting for a place in any lobby of a schedule, oldest first
+var admissionQueues = make(map[string][]string) // Schedule ID -> visitor IDs
+
+// Whether a lobby has room for another visitor, keeping places for RSVPs
+// that haven't arrived yet. Must be called with mu held.
+func hasRoom(screening *Screening) bool {
+	expected := len(screeningRSVPs(screening.ID, RSVPConfirmed))
+	return occupants(screening.ID)+expected < screening.Seats.Layout.capacity()
+}
+
+// Get the lobbies of a schedule that haven't ended, the original first. Must be called with mu held.
+func scheduleLobbies(scheduleID string, now time.Time) []*Screening {
+	var lobbies []*Screening
+	for _, screening := range screenings {
+		if screening.ScheduleID == scheduleID && now.Before(screening.EndTime) {
+			lobbies = append(lobbies, screening)
+		}
+	}
+	sort.Slice(lobbies, func(i, j int) bool {
+		if lobbies[i].Overflow != lobbies[j].Overflow {
+			return !lobbies[i].Overflow
+		}
+		return lobbies[i].ID < lobbies[j].ID
+	})
+	return lobbies
+}
+
+// Count the lobbies open in a theater: those showing now, plus any of the
+// given schedule that haven't started. Later showings don't count until they
+// start. Must be called with mu held.
+func theaterLobbies(theaterID, scheduleID string, now time.Time) int {
+	count := 0
+	for _, screening := range screenings {
+		if screening.TheaterID != theaterID || !now.Before(screening.EndTime) {
+			continue
+		}
+		if !now.Before(screening.StartTime) || screening.ScheduleID == scheduleID {
+			count++
+		}
+	}
+	return count
+}
+
+// Find a lobby of the screening's schedule with room, opening an overflow
+// lobby if the theater is under its cap. Returns nil when everything is full.
+// Must be called with mu held.
+func findLobby(screening *Screening, now time.Time) *Screening {
+	if hasRoom(screening) {
+		return screening
+	}
+	for _, lobby := range scheduleLobbies(screening.ScheduleID, now) {
+		if hasRoom(lobby) {
+			return lobby
+		}
+	}
+
+	// Watch party rooms never overflow
+	if screening.TheaterID == RoomTheaterID || theaterLobbies(screening.TheaterID, screening.ScheduleID, now) >= config.MaxLobbiesPerTheater {
+		return nil
+	}
+	return openOverflowLobby(screening)
+}
+
+// Open another lobby showing the same schedule in step with the original. Must be called with mu held.
+func openOverflowLobby(screening *Screening) *Screening {
+	playback := *screening.Playback
+	lobby := &Screening{
+		ID:         uuid.New().String(),
+		ScheduleID: screening.ScheduleID,
+		TheaterID:  screening.TheaterID,
+		FilmID:     screening.FilmID,
+		Title:      screening.Title,
+		MagnetLink: screening.MagnetLink,
+		Files:      screening.Files,
+		StartTime:  screening.StartTime,
+		EndTime:    screening.EndTime,
+		Overflow:   true,
+		Seats: &Seats{
+			Occupied: []SeatPosition{},
+			Held:     []SeatHold{},
+		},
+		Playback: &playback,
+	}
+	lobby.Seats.applyLayout(screening.Seats.Layout)
+	screenings[lobby.ID] = lobby
+
+	log.Printf("Opened overflow lobby %s for schedule %s", lobby.ID, lobby.ScheduleID)
+	return lobby
+}
+
+// Put a visitor at the back of a schedule's queue. Must be called with mu held.
+func enqueue(visitor *Visitor, scheduleID string) {
+	visitor.queuedFor = scheduleID
+	admissionQueues[scheduleID] = append(admissionQueues[scheduleID], visitor.ID)
+	visitor.queuePosition = len(admissionQueues[scheduleID])
+}
+
+// Take a visitor out of whatever queue they are in. Must be called with mu held.
+func leaveQueue(visitor *Visitor) {
+	if visitor.queuedFor == "" {
+		return
+	}
+	queue := admissionQueues[visitor.queuedFor]
+	for i, visitorID := range queue {
+		if visitorID == visitor.ID {
+			admissionQueues[visitor.queuedFor] = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	visitor.queuedFor = ""
+	visitor.queuePosition = 0
+}
+
+// Tell a queued visitor where they stand
+func queuePositionMessage(visitor *Visitor) WebSocketMessage {
+	return WebSocketMessage{
+		Type: "queue_position",
+		Data: gin.H{
+			"position":     visitor.queuePosition,
+			"queue_length": len(admissionQueues[visitor.queuedFor]),
+		},
+	}
+}
+
+// Tell a visitor which lobby they were admitted to, with a token for it
+func admissionMessage(visitor *Visitor) (WebSocketMessage, error) {
+	token, err := signVisitorToken(visitor.ID, visitor.Name, visitor.ScreeningID, visitor.grant)
+	if err != nil {
+		return WebSocketMessage{}, err
+	}
+	return WebSocketMessage{
+		Type: "admitted",
+		Data: gin.H{
+			"screening_id": visitor.ScreeningID,
+			"token":        token,
+		},
+	}, nil
+}
+
+// Move a visitor from the queue into a lobby. Must be called with mu held.
+func admitFromQueue(visitor *Visitor, lobby *Screening, now time.Time) {
+	leaveQueue(visitor)
+	visitor.ScreeningID = lobby.ID
+	visitor.LastActive = now
+
+	message, err := admissionMessage(visitor)
+	if err != nil {
+		log.Printf("Failed to sign admission token: %v", err)
+		return
+	}
+	sendToVisitor(visitor.ID, message)
+
+	broadcastToScreening(lobby.ID, WebSocketMessage{
+		Type: "visitor_joined",
+		Data: gin.H{
+			"visitor": gin.H{
+				"id":   visitor.ID,
+				"name": visitor.Name,
+			},
+		},
+	})
+}
+
+// Admit queued visitors wherever places have opened up, update everyone
+// else's position and close overflow lobbies that have emptied. Must be called
+// with mu held.
+func processQueues(now time.Time) {
+	for scheduleID, queue := range admissionQueues {
+		lobbies := scheduleLobbies(scheduleID, now)
+		for len(queue) > 0 && len(lobbies) > 0 {
+			lobby := findLobby(lobbies[0], now)
+			if lobby == nil {
+				break
+			}
+			admitFromQueue(visitors[queue[0]], lobby, now)
+			queue = admissionQueues[scheduleID]
+		}
+
+		// The showing is over; nobody is getting in, and with no lobby to
+		// return to the visitors are removed
+		if len(lobbies) == 0 {
+			for _, visitorID := range queue {
+				sendToVisitor(visitorID, WebSocketMessage{Type: "queue_closed"})
+				visitors[visitorID].queuedFor = ""
+				removeVisitor(visitorID)
+			}
+			queue = nil
+		}
+
+		for i, visitorID := range queue {
+			visitor := visitors[visitorID]
+			if visitor.queuePosition != i+1 {
+				visitor.queuePosition = i + 1
+				sendToVisitor(visitorID, queuePositionMessage(visitor))
+			}
+		}
+
+		if len(queue) == 0 {
+			delete(admissionQueues, scheduleID)
+		}
+	}
+
+	for screeningID, screening := range screenings {
+		if screening.Overflow && occupants(screeningID) == 0 && len(admissionQueues[screening.ScheduleID]) == 0 {
+			delete(screenings, screeningID)
+			delete(chatHistory, screeningID)
+		}
+	}
+}
+
+// Run the admission queues periodically
+func admitQueuedVisitors() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		mu.Lock()
+		processQueues(now)
+		mu.Unlock()
+	}
+}
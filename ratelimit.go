@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RateLimit configures a token bucket that refills at Rate tokens per second
+// and holds at most Burst tokens
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst float64 `json:"burst"`
+}
+
+// Default limits per route or WebSocket message type
+var defaultRateLimits = map[string]RateLimit{
+	"api":       {Rate: 20, Burst: 60},  // Every REST request, per IP
+	"auth":      {Rate: 0.2, Burst: 5},  // Visitor and operator token creation, per IP
+	"challenge": {Rate: 1, Burst: 10},   // Proof-of-work challenges, per IP
+	"seat":      {Rate: 1, Burst: 5},    // Seat selection and release, per visitor
+	"rsvp":      {Rate: 0.1, Burst: 5},  // RSVPs and cancellations, per IP
+	"room":      {Rate: 0.02, Burst: 2}, // Watch party creation, per visitor
+	"ice":       {Rate: 0.1, Burst: 5},  // ICE server credentials, per visitor
+	"tracker":   {Rate: 5, Burst: 50},   // Tracker announces and scrapes, per IP
+	"stun":      {Rate: 5, Burst: 20},   // STUN binding requests, per IP
+	"chat":      {Rate: 1, Burst: 5},    // Chat messages, per visitor
+	"signal":    {Rate: 50, Burst: 200}, // WebRTC signaling, per visitor
+	"position":  {Rate: 30, Burst: 60},  // Position updates, per visitor
+	"ws":        {Rate: 10, Burst: 30},  // Any other WebSocket message, per visitor or IP
+}
+
+// tokenBucket tracks the remaining tokens for one key
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds the buckets of one named limit
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   RateLimit
+	buckets map[string]*tokenBucket
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[string]*rateLimiter)
+)
+
+// Parse limit overrides from "name=rate:burst,name=rate:burst"
+func parseRateLimits(value string) map[string]RateLimit {
+	limits := make(map[string]RateLimit)
+	for name, limit := range defaultRateLimits {
+		limits[name] = limit
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		rateText, burstText, ok2 := strings.Cut(spec, ":")
+		rate, err := strconv.ParseFloat(rateText, 64)
+		burst, err2 := strconv.ParseFloat(burstText, 64)
+		if !ok || !ok2 || err != nil || err2 != nil || rate <= 0 || burst < 1 {
+			log.Printf("Ignoring malformed rate limit %q", entry)
+			continue
+		}
+		limits[name] = RateLimit{Rate: rate, Burst: burst}
+	}
+	return limits
+}
+
+// Get the limiter for a named limit, creating it on first use
+func getRateLimiter(name string) *rateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	limiter, exists := rateLimiters[name]
+	if !exists {
+		limit, configured := config.RateLimits[name]
+		if !configured {
+			limit = config.RateLimits["ws"]
+		}
+		limiter = &rateLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+		rateLimiters[name] = limiter
+	}
+	return limiter
+}
+
+// Take a token for a key. When none is left, returns false and how long
+// until one will be available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.limit.Burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(l.limit.Burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.limit.Rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.limit.Rate * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Check a named limit for a key
+func allowRate(name, key string) (bool, time.Duration) {
+	return getRateLimiter(name).allow(name + ":" + key)
+}
+
+// Drop buckets that have refilled completely so idle keys don't pile up
+func sweepRateLimiters() {
+	for {
+		time.Sleep(1 * time.Minute)
+
+		rateLimitersMu.Lock()
+		limiters := make([]*rateLimiter, 0, len(rateLimiters))
+		for _, limiter := range rateLimiters {
+			limiters = append(limiters, limiter)
+		}
+		rateLimitersMu.Unlock()
+
+		now := time.Now()
+		for _, limiter := range limiters {
+			limiter.mu.Lock()
+			full := time.Duration(limiter.limit.Burst / limiter.limit.Rate * float64(time.Second))
+			for key, bucket := range limiter.buckets {
+				if now.Sub(bucket.last) > full {
+					delete(limiter.buckets, key)
+				}
+			}
+			limiter.mu.Unlock()
+		}
+	}
+}
+
+// Respond with 429 when a key has exhausted its limit
+func abortIfLimited(c *gin.Context, name, key string) {
+	if ok, wait := allowRate(name, key); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":       "Rate limit exceeded",
+			"retry_after": wait.Seconds(),
+		})
+		return
+	}
+	c.Next()
+}
+
+// Limit requests per client IP
+func rateLimitByIP(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		abortIfLimited(c, name, "ip:"+c.ClientIP())
+	}
+}
+
+// Limit requests per visitor, falling back to the client IP without a valid token
+func rateLimitByVisitor(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if visitorID, err := tokenSubject(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")); err == nil {
+			key = "visitor:" + visitorID
+		}
+		abortIfLimited(c, name, key)
+	}
+}
+
+// Get the subject of a signed token without looking up any state
+func tokenSubject(tokenString string) (string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.JWTSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return "", fmt.Errorf("invalid token")
+	}
+
+	subject, err := token.Claims.GetSubject()
+	if err != nil || subject == "" {
+		return "", fmt.Errorf("invalid token subject")
+	}
+	return subject, nil
+}
+
+// Map a WebSocket message type to its rate limit
+func wsRateLimitName(messageType string) string {
+	switch messageType {
+	case "chat":
+		return "chat"
+	case "webrtc_signal":
+		return "signal"
+	case "position_update":
+		return "position"
+	default:
+		return "ws"
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		key   string
+		want  RateLimit
+	}{
+		{"default kept", "", "chat", defaultRateLimits["chat"]},
+		{"override", "chat=2:10", "chat", RateLimit{Rate: 2, Burst: 10}},
+		{"new limit", " custom=0.5:3 ", "custom", RateLimit{Rate: 0.5, Burst: 3}},
+		{"later entry wins", "chat=2:10,chat=3:12", "chat", RateLimit{Rate: 3, Burst: 12}},
+		{"missing burst ignored", "chat=2", "chat", defaultRateLimits["chat"]},
+		{"zero rate ignored", "chat=0:10", "chat", defaultRateLimits["chat"]},
+		{"burst below one ignored", "chat=2:0.5", "chat", defaultRateLimits["chat"]},
+		{"garbage ignored", "chat=fast:lots", "chat", defaultRateLimits["chat"]},
+		{"bad entry skips only itself", "chat=x:y,seat=4:8", "seat", RateLimit{Rate: 4, Burst: 8}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := parseRateLimits(tt.value)
+			if got := limits[tt.key]; got != tt.want {
+				t.Errorf("parseRateLimits(%q)[%q] = %+v, want %+v", tt.value, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   RateLimit
+		elapsed time.Duration // Time since the bucket was last used, before the final request
+		used    int           // Requests made before the final one
+		want    bool
+	}{
+		{"fresh bucket", RateLimit{Rate: 1, Burst: 3}, 0, 0, true},
+		{"within burst", RateLimit{Rate: 1, Burst: 3}, 0, 2, true},
+		{"burst used up", RateLimit{Rate: 1, Burst: 3}, 0, 3, false},
+		{"refilled one token", RateLimit{Rate: 1, Burst: 3}, 1100 * time.Millisecond, 3, true},
+		{"not yet refilled", RateLimit{Rate: 0.5, Burst: 3}, time.Second, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &rateLimiter{limit: tt.limit, buckets: make(map[string]*tokenBucket)}
+			for i := 0; i < tt.used; i++ {
+				limiter.allow("key")
+			}
+			if bucket, exists := limiter.buckets["key"]; exists {
+				bucket.last = bucket.last.Add(-tt.elapsed)
+			}
+
+			allowed, wait := limiter.allow("key")
+			if allowed != tt.want {
+				t.Fatalf("allow() = %v, want %v", allowed, tt.want)
+			}
+			if allowed && wait != 0 {
+				t.Errorf("allowed request waits %v", wait)
+			}
+			if !allowed && (wait <= 0 || wait > time.Duration(float64(time.Second)/tt.limit.Rate)) {
+				t.Errorf("denied request waits %v", wait)
+			}
+		})
+	}
+
+	t.Run("keys are independent", func(t *testing.T) {
+		limiter := &rateLimiter{limit: RateLimit{Rate: 1, Burst: 1}, buckets: make(map[string]*tokenBucket)}
+		if allowed, _ := limiter.allow("a"); !allowed {
+			t.Fatal("first request for a denied")
+		}
+		if allowed, _ := limiter.allow("a"); allowed {
+			t.Fatal("second request for a allowed")
+		}
+		if allowed, _ := limiter.allow("b"); !allowed {
+			t.Fatal("first request for b denied")
+		}
+	})
+
+	t.Run("refill capped at burst", func(t *testing.T) {
+		limiter := &rateLimiter{limit: RateLimit{Rate: 10, Burst: 2}, buckets: make(map[string]*tokenBucket)}
+		limiter.allow("key")
+		limiter.buckets["key"].last = time.Now().Add(-time.Hour)
+		for i := 0; i < 2; i++ {
+			if allowed, _ := limiter.allow("key"); !allowed {
+				t.Fatalf("request %d after a long idle denied", i+1)
+			}
+		}
+		if allowed, _ := limiter.allow("key"); allowed {
+			t.Fatal("idle bucket refilled beyond its burst")
+		}
+	})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Theater that visitor-created rooms are laid out from
+const RoomTheaterID = "rooms"
+
+// Room is a temporary, invite-only screening a visitor started from a
+// catalog film. It closes once everyone has left.
+type Room struct {
+	ScreeningID string    `json:"screening_id"`
+	FilmID      string    `json:"film_id"`
+	HostID      string    `json:"host_id"`
+	HostName    string    `json:"host_name"`
+	CreatedAt   time.Time `json:"created_at"`
+	InviteID    string    `json:"-"`
+
+	creatorID  string // Visitor that asked for the room; the host is a new visitor
+	hostIPHash string
+}
+
+var rooms = make(map[string]*Room) // Screening ID -> room
+
+// Count the rooms a visitor created or hosts, or anyone at their address is
+// hosting. Must be called with mu held.
+func hostedRooms(visitor *Visitor) int {
+	count := 0
+	for _, room := range rooms {
+		if room.creatorID == visitor.ID || room.HostID == visitor.ID ||
+			(visitor.IPHash != "" && room.hostIPHash == visitor.IPHash) {
+			count++
+		}
+	}
+	return count
+}
+
+// Count the visitors in a screening. Must be called with mu held.
+func occupants(screeningID string) int {
+	count := 0
+	for _, visitor := range visitors {
+		if visitor.ScreeningID == screeningID {
+			count++
+		}
+	}
+	return count
+}
+
+// Whether a visitor hosts the room showing a screening. Must be called with mu held.
+func isRoomHost(screeningID, visitorID string) bool {
+	room, exists := rooms[screeningID]
+	return exists && room.HostID == visitorID
+}
+
+// Create a watch party room from a catalog film. The host gets a new visitor
+// token for the room and a link to invite friends.
+func createRoom(c *gin.Context) {
+	var request struct {
+		FilmID string `json:"film_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	visitorID, err := verifyToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if !config.WatchPartiesEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Watch parties are disabled on this instance"})
+		return
+	}
+
+	film, exists := films[request.FilmID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Film not found"})
+		return
+	}
+
+	visitor := visitors[visitorID]
+	if hostedRooms(visitor) >= config.WatchPartyLimit {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "You already have the maximum number of rooms open"})
+		return
+	}
+
+	// Rooms share one layout operators can change like any theater's
+	layout, exists := theaterLayouts[RoomTheaterID]
+	if !exists {
+		layout = rectangularLayout(3, 8)
+		theaterLayouts[RoomTheaterID] = layout
+	}
+
+	// Start paused so the host can wait for friends before pressing play
+	now := time.Now()
+	screening := &Screening{
+		ID:         uuid.New().String(),
+		TheaterID:  RoomTheaterID,
+		FilmID:     film.ID,
+		Title:      film.Title,
+		MagnetLink: film.MagnetLink,
+		Files:      defaultFileSelection(film),
+		StartTime:  now,
+		EndTime:    now.Add(film.duration()),
+		Seats: &Seats{
+			Occupied: []SeatPosition{},
+			Held:     []SeatHold{},
+		},
+		Playback: newPlayback(now),
+	}
+	screening.ScheduleID = screening.ID
+	screening.Seats.applyLayout(layout)
+	screening.Playback.pause(now)
+
+	invite := &Invite{
+		ID:          uuid.New().String(),
+		Scope:       ScopeSchedule,
+		ScopeID:     screening.ScheduleID,
+		ScreeningID: screening.ID,
+		CreatedBy:   visitor.Name,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(24 * time.Hour),
+	}
+	inviteToken, err := signInvite(invite)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate invite"})
+		return
+	}
+
+	// The host joins the room as a new visitor holding its grant
+	hostID := uuid.New().String()
+	grant := ScopeSchedule + ":" + screening.ScheduleID
+	hostToken, err := signVisitorToken(hostID, visitor.Name, screening.ID, grant)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	screenings[screening.ID] = screening
+	scheduleAccess[screening.ScheduleID] = &AccessPolicy{Mode: AccessInvite}
+	invites[invite.ID] = invite
+	visitors[hostID] = &Visitor{
+		ID:          hostID,
+		Name:        visitor.Name,
+		ScreeningID: screening.ID,
+		LastActive:  now,
+		IPHash:      visitor.IPHash,
+	}
+
+	room := &Room{
+		ScreeningID: screening.ID,
+		FilmID:      film.ID,
+		HostID:      hostID,
+		HostName:    visitor.Name,
+		CreatedAt:   now,
+		InviteID:    invite.ID,
+		creatorID:   visitor.ID,
+		hostIPHash:  visitor.IPHash,
+	}
+	rooms[screening.ID] = room
+
+	c.JSON(http.StatusCreated, gin.H{
+		"room":       room,
+		"screening":  screeningResponse(c, screening, hostID),
+		"token":      hostToken,
+		"visitor_id": hostID,
+		"invite":     inviteToken,
+		"link":       inviteLink(screening.ID, inviteToken),
+	})
+}
+
+// Control playback of a room as its host
+func controlRoom(c *gin.Context) {
+	var cmd ProjectionCommand
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := cmd.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	visitorID, err := verifyToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if !isRoomHost(c.Param("id"), visitorID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the host can control this room"})
+		return
+	}
+
+	screening := screenings[c.Param("id")]
+	projectSchedule(screening, cmd, visitors[visitorID].Name)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"playback": screening.Playback,
+	})
+}
+
+// Close a room, disconnecting anyone still inside. Must be called with mu held.
+func closeRoom(room *Room) {
+	broadcastToScreening(room.ScreeningID, WebSocketMessage{
+		Type: "room_closed",
+		Data: gin.H{"screening_id": room.ScreeningID},
+	})
+	for visitorID, visitor := range visitors {
+		if visitor.ScreeningID == room.ScreeningID {
+			removeVisitor(visitorID)
+		}
+	}
+
+	delete(rooms, room.ScreeningID)
+	delete(screenings, room.ScreeningID)
+	delete(scheduleAccess, room.ScreeningID)
+	delete(invites, room.InviteID)
+	delete(chatHistory, room.ScreeningID)
+}
+
+// Get the watch party settings
+func getWatchPartySettings(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":           config.WatchPartiesEnabled,
+		"limit_per_visitor": config.WatchPartyLimit,
+		"open_rooms":        len(rooms),
+	})
+}
+
+// Turn watch parties on or off and set the per-visitor room limit. Open rooms
+// are left running.
+func updateWatchPartySettings(c *gin.Context) {
+	var request struct {
+		Enabled         *bool `json:"enabled"`
+		LimitPerVisitor *int  `json:"limit_per_visitor"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil || (request.LimitPerVisitor != nil && *request.LimitPerVisitor < 1) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if request.Enabled != nil {
+		config.WatchPartiesEnabled = *request.Enabled
+	}
+	if request.LimitPerVisitor != nil {
+		config.WatchPartyLimit = *request.LimitPerVisitor
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":           config.WatchPartiesEnabled,
+		"limit_per_visitor": config.WatchPartyLimit,
+		"open_rooms":        len(rooms),
+	})
+}
+
+// Close rooms that everyone has left or whose film has finished
+func expireRooms() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		mu.Lock()
+		for _, room := range rooms {
+			screening := screenings[room.ScreeningID]
+			empty := occupants(room.ScreeningID) == 0 && now.Sub(room.CreatedAt) > 2*time.Minute
+			finished := !screening.Playback.Paused && now.After(screening.EndTime)
+			if empty || finished {
+				closeRoom(room)
+			}
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RSVP statuses
+const (
+	RSVPConfirmed  = "confirmed"
+	RSVPWaitlisted = "waitlisted"
+	RSVPArrived    = "arrived"
+	RSVPCancelled  = "cancelled"
+	RSVPNoShow     = "no_show"
+	RSVPLeft       = "left" // Arrived, then the visitor left and gave up the place
+)
+
+// RSVP represents a visitor's advance reservation for a scheduled screening.
+// The code is the only credential: visitors present it when they join.
+type RSVP struct {
+	Code        string        `json:"code"`
+	ScreeningID string        `json:"screening_id"`
+	Name        string        `json:"name"`
+	Seat        *SeatPosition `json:"seat,omitempty"` // Requested seat, reserved while confirmed
+	Status      string        `json:"status"`
+	CreatedAt   time.Time     `json:"created_at"`
+	Deadline    time.Time     `json:"deadline"` // Confirmed RSVPs not claimed by then are no-shows
+	VisitorID   string        `json:"-"`        // Visitor that claimed the RSVP
+	Grant       string        `json:"-"`        // Private screening access granted when RSVPing
+
+	sequence int64 // Order of confirmation or waitlisting
+}
+
+var (
+	rsvps            = make(map[string]*RSVP) // Code -> RSVP
+	nextRSVPSequence int64
+)
+
+// Get a screening's RSVPs with the given statuses in order. Must be called with mu held.
+func screeningRSVPs(screeningID string, statuses ...string) []*RSVP {
+	var result []*RSVP
+	for _, rsvp := range rsvps {
+		if rsvp.ScreeningID != screeningID {
+			continue
+		}
+		for _, status := range statuses {
+			if rsvp.Status == status {
+				result = append(result, rsvp)
+				break
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].sequence < result[j].sequence
+	})
+	return result
+}
+
+// Count the confirmed places left in a screening. Must be called with mu held.
+func rsvpRemaining(screening *Screening) int {
+	return screening.Seats.Layout.capacity() - len(screeningRSVPs(screening.ID, RSVPConfirmed, RSVPArrived))
+}
+
+// Whether a visitor may take a seat without using one kept for confirmed
+// RSVPs that haven't arrived. Seated visitors moving and seats held for the
+// visitor never use another place. Must be called with mu held.
+func rsvpAllowsSeat(screening *Screening, visitor *Visitor, row, position int) bool {
+	if hold := screening.Seats.hold(row, position); hold != nil && hold.heldFor(visitor) {
+		return true
+	}
+	for _, seat := range screening.Seats.Occupied {
+		if seat.VisitorID == visitor.ID {
+			return true
+		}
+	}
+
+	return rsvpSpareSeats(screening) > 0
+}
+
+// Number of unheld free seats beyond those kept for confirmed RSVPs without
+// a particular seat. Must be called with mu held.
+func rsvpSpareSeats(screening *Screening) int {
+	// RSVPs with a particular seat already hold it
+	expected := 0
+	for _, rsvp := range screeningRSVPs(screening.ID, RSVPConfirmed) {
+		if rsvp.Seat == nil {
+			expected++
+		}
+	}
+	free := screening.Seats.Layout.capacity() - len(screening.Seats.Occupied) - len(screening.Seats.Held)
+	return free - expected
+}
+
+// Position of an RSVP in the waitlist, starting at 1, or 0 if not waitlisted. Must be called with mu held.
+func waitlistPosition(rsvp *RSVP) int {
+	for i, waiting := range screeningRSVPs(rsvp.ScreeningID, RSVPWaitlisted) {
+		if waiting == rsvp {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Confirm an RSVP, reserving its seat if that seat is still free. Must be called with mu held.
+func confirmRSVP(screening *Screening, rsvp *RSVP, now time.Time) {
+	nextRSVPSequence++
+	rsvp.sequence = nextRSVPSequence
+	rsvp.Status = RSVPConfirmed
+
+	// Visitors promoted after the start get the full grace period too
+	rsvp.Deadline = screening.StartTime
+	if now.After(rsvp.Deadline) {
+		rsvp.Deadline = now
+	}
+	rsvp.Deadline = rsvp.Deadline.Add(config.RSVPGracePeriod)
+
+	if rsvp.Seat != nil {
+		if !screening.Seats.free(rsvp.Seat.Row, rsvp.Seat.Seat) {
+			rsvp.Seat = nil
+			return
+		}
+		screening.Seats.Held = append(screening.Seats.Held, SeatHold{
+			Row:       rsvp.Seat.Row,
+			Seat:      rsvp.Seat.Seat,
+			ExpiresAt: rsvp.Deadline,
+			RSVPCode:  rsvp.Code,
+		})
+	}
+}
+
+// Stop reserving an RSVP's seat. Must be called with mu held.
+func releaseRSVPSeat(screening *Screening, rsvp *RSVP) {
+	screening.Seats.filterHolds(func(hold SeatHold) bool {
+		return hold.RSVPCode != rsvp.Code
+	})
+}
+
+// Promote waitlisted RSVPs while there is room, telling any that are
+// already present. Returns whether anything changed. Must be called with mu held.
+func promoteWaitlist(screening *Screening, now time.Time) bool {
+	promoted := false
+	for _, rsvp := range screeningRSVPs(screening.ID, RSVPWaitlisted) {
+		if rsvpRemaining(screening) <= 0 {
+			break
+		}
+		confirmRSVP(screening, rsvp, now)
+		promoted = true
+
+		if rsvp.VisitorID != "" {
+			sendToVisitor(rsvp.VisitorID, WebSocketMessage{
+				Type: "rsvp_promoted",
+				Data: rsvp,
+			})
+		}
+	}
+	return promoted
+}
+
+// Mark confirmed RSVPs that missed their deadline as no-shows and fill their
+// places from the waitlist. Must be called with mu held.
+func processRSVPs(now time.Time) {
+	for screeningID, screening := range screenings {
+		changed := false
+		for _, rsvp := range screeningRSVPs(screeningID, RSVPConfirmed) {
+			if now.After(rsvp.Deadline) {
+				rsvp.Status = RSVPNoShow
+				releaseRSVPSeat(screening, rsvp)
+				changed = true
+			}
+		}
+		if changed {
+			promoteWaitlist(screening, now)
+			broadcastSeats(screeningID, screening)
+		}
+	}
+}
+
+// Link a joining visitor to their RSVP and seat them in their reserved seat.
+// Must be called with mu held.
+func claimRSVP(visitor *Visitor, code string) {
+	rsvp, exists := rsvps[strings.ToUpper(code)]
+	if !exists || rsvp.ScreeningID != visitor.ScreeningID || (rsvp.VisitorID != "" && rsvp.VisitorID != visitor.ID) {
+		return
+	}
+	screening, exists := screenings[rsvp.ScreeningID]
+	if !exists {
+		return
+	}
+
+	visitor.RSVPCode = rsvp.Code
+	rsvp.VisitorID = visitor.ID
+	if rsvp.Status != RSVPConfirmed {
+		return
+	}
+
+	rsvp.Status = RSVPArrived
+	if rsvp.Seat != nil {
+		if _, message := checkSeat(screening, rsvp.Seat.Row, rsvp.Seat.Seat, visitor); message == "" {
+			assignSeat(screening, visitor, rsvp.Seat.Row, rsvp.Seat.Seat)
+			broadcastSeats(screening.ID, screening)
+		}
+	}
+}
+
+// Give up the place of a visitor who arrived with an RSVP and has left, so
+// it goes to the waitlist. Must be called with mu held.
+func leaveRSVP(visitor *Visitor) {
+	rsvp, exists := rsvps[visitor.RSVPCode]
+	if !exists || rsvp.VisitorID != visitor.ID || rsvp.Status != RSVPArrived {
+		return
+	}
+	rsvp.Status = RSVPLeft
+
+	if screening, exists := screenings[rsvp.ScreeningID]; exists && promoteWaitlist(screening, time.Now()) {
+		broadcastSeats(screening.ID, screening)
+	}
+}
+
+// Look up the screening in the URL without falling back to the default. Must be called with mu held.
+func rsvpScreening(c *gin.Context) *Screening {
+	screening, exists := screenings[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Screening not found"})
+		return nil
+	}
+	return screening
+}
+
+// Get RSVP capacity for a screening
+func getRSVPSummary(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	screening := rsvpScreening(c)
+	if screening == nil {
+		return
+	}
+
+	remaining := rsvpRemaining(screening)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"screening_id": screening.ID,
+		"capacity":     screening.Seats.Layout.capacity(),
+		"confirmed":    len(screeningRSVPs(screening.ID, RSVPConfirmed, RSVPArrived)),
+		"remaining":    remaining,
+		"waitlisted":   len(screeningRSVPs(screening.ID, RSVPWaitlisted)),
+	})
+}
+
+// RSVP to a scheduled screening, joining the waitlist when it is full
+func createRSVP(c *gin.Context) {
+	var request struct {
+		Name       string `json:"name" binding:"required"`
+		RowNumber  *int   `json:"row_number"`
+		SeatNumber *int   `json:"seat_number"`
+		Passcode   string `json:"passcode"`
+		Invite     string `json:"invite"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil || (request.RowNumber == nil) != (request.SeatNumber == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if containsFilteredWord(request.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Display name is not allowed"})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	screening := rsvpScreening(c)
+	if screening == nil {
+		return
+	}
+
+	now := time.Now()
+	if !now.Before(screening.StartTime) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Screening has already started"})
+		return
+	}
+
+	rsvp := &RSVP{
+		Code:        strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12]),
+		ScreeningID: screening.ID,
+		Name:        request.Name,
+		CreatedAt:   now,
+	}
+
+	if request.RowNumber != nil {
+		screening.Seats.pruneHolds(now)
+		if !screening.Seats.Layout.selectable(*request.RowNumber, *request.SeatNumber) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid seat"})
+			return
+		}
+		if !screening.Seats.free(*request.RowNumber, *request.SeatNumber) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Seat is already reserved"})
+			return
+		}
+		rsvp.Seat = &SeatPosition{Row: *request.RowNumber, Seat: *request.SeatNumber}
+	}
+
+	// Private screenings take RSVPs from invitees only; the grant carries over when they join
+	grant, status, response := admit(screening, request.Passcode, request.Invite, now)
+	if response != nil {
+		c.JSON(status, response)
+		return
+	}
+	rsvp.Grant = grant
+
+	rsvps[rsvp.Code] = rsvp
+	if rsvpRemaining(screening) > 0 {
+		confirmRSVP(screening, rsvp, now)
+		broadcastSeats(screening.ID, screening)
+	} else {
+		nextRSVPSequence++
+		rsvp.sequence = nextRSVPSequence
+		rsvp.Status = RSVPWaitlisted
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"rsvp":              rsvp,
+		"waitlist_position": waitlistPosition(rsvp),
+	})
+}
+
+// Get an RSVP by code
+func getRSVP(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rsvp, exists := rsvps[strings.ToUpper(c.Param("code"))]
+	if !exists || rsvp.ScreeningID != c.Param("id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "RSVP not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rsvp":              rsvp,
+		"waitlist_position": waitlistPosition(rsvp),
+	})
+}
+
+// Cancel an RSVP, promoting the next visitor on the waitlist
+func cancelRSVP(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	screening := rsvpScreening(c)
+	if screening == nil {
+		return
+	}
+
+	rsvp, exists := rsvps[strings.ToUpper(c.Param("code"))]
+	if !exists || rsvp.ScreeningID != screening.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "RSVP not found"})
+		return
+	}
+
+	if rsvp.Status != RSVPConfirmed && rsvp.Status != RSVPWaitlisted {
+		c.JSON(http.StatusConflict, gin.H{"error": "RSVP can no longer be cancelled"})
+		return
+	}
+
+	rsvp.Status = RSVPCancelled
+	releaseRSVPSeat(screening, rsvp)
+	promoteWaitlist(screening, time.Now())
+	broadcastSeats(screening.ID, screening)
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Expire no-shows periodically
+func expireRSVPs() {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		mu.Lock()
+		processRSVPs(now)
+		mu.Unlock()
+	}
+}
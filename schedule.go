@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Create a scheduled screening, either of a catalog film or of a magnet link
+func createScreening(c *gin.Context) {
+	var request struct {
+		FilmID     string    `json:"film_id"`
+		Title      string    `json:"title"`
+		MagnetLink string    `json:"magnet_link"`
+		TheaterID  string    `json:"theater_id"`
+		ScheduleID string    `json:"schedule_id"`
+		StartTime  time.Time `json:"start_time" binding:"required"`
+		EndTime    time.Time `json:"end_time"`
+		FileSelectionRequest
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if request.TheaterID == "" {
+		request.TheaterID = "default"
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Catalog films fill in the title, magnet link, running time and which of
+	// the torrent's files to play
+	var selection *FileSelection
+	if request.FilmID != "" {
+		film, exists := films[request.FilmID]
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Film not found"})
+			return
+		}
+		var message string
+		if selection, message = request.FileSelectionRequest.resolve(film); message != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": message})
+			return
+		}
+		request.Title = film.Title
+		request.MagnetLink = film.MagnetLink
+		if request.EndTime.IsZero() {
+			request.EndTime = request.StartTime.Add(film.duration())
+		}
+	}
+
+	if request.Title == "" || request.MagnetLink == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A film or a title and magnet link is required"})
+		return
+	}
+
+	if request.FilmID == "" {
+		magnet, err := parseMagnet(request.MagnetLink)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid magnet link: " + err.Error()})
+			return
+		}
+		request.MagnetLink = magnet.String()
+	}
+
+	if !request.EndTime.After(request.StartTime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "End time must be after start time"})
+		return
+	}
+
+	// Theaters without a custom layout get the default rectangle
+	layout, exists := theaterLayouts[request.TheaterID]
+	if !exists {
+		layout = rectangularLayout(5, 10)
+		theaterLayouts[request.TheaterID] = layout
+	}
+
+	screening := &Screening{
+		ID:         uuid.New().String(),
+		ScheduleID: request.ScheduleID,
+		TheaterID:  request.TheaterID,
+		FilmID:     request.FilmID,
+		Title:      request.Title,
+		MagnetLink: request.MagnetLink,
+		Files:      selection,
+		StartTime:  request.StartTime,
+		EndTime:    request.EndTime,
+		Seats: &Seats{
+			Occupied: []SeatPosition{},
+			Held:     []SeatHold{},
+		},
+		Playback: newPlayback(request.StartTime),
+	}
+	if screening.ScheduleID == "" {
+		screening.ScheduleID = screening.ID
+	}
+	screening.Seats.applyLayout(layout)
+	screenings[screening.ID] = screening
+
+	c.JSON(http.StatusCreated, screeningResponse(c, screening, ""))
+}
+
+// List public screenings that haven't ended, soonest first
+func listScreenings(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	result := []gin.H{}
+	for _, screening := range screenings {
+		if screening.EndTime.Before(now) || !listed(screening) {
+			continue
+		}
+		result = append(result, gin.H{
+			"id":          screening.ID,
+			"schedule_id": screening.ScheduleID,
+			"theater_id":  screening.TheaterID,
+			"title":       screening.Title,
+			"start_time":  screening.StartTime,
+			"end_time":    screening.EndTime,
+			"capacity":    screening.Seats.Layout.capacity(),
+			"occupied":    len(screening.Seats.Occupied),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i]["start_time"].(time.Time).Before(result[j]["start_time"].(time.Time))
+	})
+
+	c.JSON(http.StatusOK, gin.H{"screenings": result})
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoleManager can create and edit theaters
+const RoleManager = "manager"
+
+// Seat types
+const (
+	SeatStandard   = "standard"
+	SeatWheelchair = "wheelchair" // Space for a wheelchair user
+	SeatCompanion  = "companion"  // Next to a wheelchair space
+	SeatBlocked    = "blocked"    // Exists but can't be selected
+	SeatAisle      = "aisle"      // A gap in the row, not a seat
+)
+
+// Seat plan codes used by the compact layout format
+var seatPlanCodes = map[rune]string{
+	'S': SeatStandard,
+	'W': SeatWheelchair,
+	'C': SeatCompanion,
+	'B': SeatBlocked,
+	'_': SeatAisle,
+}
+
+// Layout limits
+const (
+	maxLayoutRows     = 100
+	maxLayoutRowSeats = 100
+)
+
+// SeatLayout represents the seating plan of a theater, front row first
+type SeatLayout struct {
+	Rows []SeatRow `json:"rows"`
+}
+
+// SeatRow represents one row of a seat layout
+type SeatRow struct {
+	Label string       `json:"label"`
+	Seats []LayoutSeat `json:"seats"` // Left to right, including aisles
+}
+
+// LayoutSeat represents one position in a row
+type LayoutSeat struct {
+	Label string `json:"label,omitempty"` // e.g. "F12"; empty for aisles
+	Type  string `json:"type"`
+}
+
+// Layouts per theater ID
+var theaterLayouts = make(map[string]*SeatLayout)
+
+// Build a rectangular layout of standard seats
+func rectangularLayout(rows, seatsPerRow int) *SeatLayout {
+	plan := make([]string, rows)
+	for i := range plan {
+		for j := 0; j < seatsPerRow; j++ {
+			plan[i] += "S"
+		}
+	}
+	layout, _ := layoutFromPlan(plan)
+	return layout
+}
+
+// Build a layout from compact row strings such as "WC_SSSS_CW", where
+// S is standard, W wheelchair, C companion, B blocked and _ an aisle
+func layoutFromPlan(plan []string) (*SeatLayout, error) {
+	layout := &SeatLayout{}
+	for i, row := range plan {
+		seatRow := SeatRow{}
+		for _, code := range row {
+			seatType, ok := seatPlanCodes[code]
+			if !ok {
+				return nil, fmt.Errorf("row %d: unknown seat code %q", i+1, code)
+			}
+			seatRow.Seats = append(seatRow.Seats, LayoutSeat{Type: seatType})
+		}
+		layout.Rows = append(layout.Rows, seatRow)
+	}
+	return layout, layout.normalize()
+}
+
+// Default row labels: A-Z, then AA, AB, ...
+func rowLabel(index int) string {
+	if index < 26 {
+		return string(rune('A' + index))
+	}
+	return rowLabel(index/26-1) + rowLabel(index%26)
+}
+
+// Validate the layout and fill in missing row and seat labels. Seats are
+// numbered from 1, left to right, skipping aisles.
+func (l *SeatLayout) normalize() error {
+	if len(l.Rows) == 0 || len(l.Rows) > maxLayoutRows {
+		return fmt.Errorf("layout must have between 1 and %d rows", maxLayoutRows)
+	}
+
+	labels := make(map[string]bool)
+	for i := range l.Rows {
+		row := &l.Rows[i]
+		if row.Label == "" {
+			row.Label = rowLabel(i)
+		}
+		if len(row.Seats) == 0 || len(row.Seats) > maxLayoutRowSeats {
+			return fmt.Errorf("row %s must have between 1 and %d positions", row.Label, maxLayoutRowSeats)
+		}
+
+		number := 0
+		for j := range row.Seats {
+			seat := &row.Seats[j]
+			if seat.Type == "" {
+				seat.Type = SeatStandard
+			}
+
+			switch seat.Type {
+			case SeatAisle:
+				seat.Label = ""
+				continue
+			case SeatStandard, SeatWheelchair, SeatCompanion, SeatBlocked:
+			default:
+				return fmt.Errorf("row %s: unknown seat type %q", row.Label, seat.Type)
+			}
+
+			number++
+			if seat.Label == "" {
+				seat.Label = row.Label + strconv.Itoa(number)
+			}
+			if labels[seat.Label] {
+				return fmt.Errorf("duplicate seat label %q", seat.Label)
+			}
+			labels[seat.Label] = true
+		}
+	}
+	return nil
+}
+
+// Get the seat at a row and position, or nil if there is none
+func (l *SeatLayout) seat(row, position int) *LayoutSeat {
+	if row < 0 || row >= len(l.Rows) || position < 0 || position >= len(l.Rows[row].Seats) {
+		return nil
+	}
+	return &l.Rows[row].Seats[position]
+}
+
+// Check whether a visitor can sit at a row and position
+func (l *SeatLayout) selectable(row, position int) bool {
+	seat := l.seat(row, position)
+	return seat != nil && seat.Type != SeatAisle && seat.Type != SeatBlocked
+}
+
+// Count the seats visitors can select
+func (l *SeatLayout) capacity() int {
+	count := 0
+	for i, row := range l.Rows {
+		for j := range row.Seats {
+			if l.selectable(i, j) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Point a screening's seats at a layout, keeping the rectangular bounds for
+// older clients and releasing any occupied seat that no longer exists.
+// Must be called with mu held.
+func (s *Seats) applyLayout(layout *SeatLayout) []SeatPosition {
+	s.Layout = layout
+	s.Rows = len(layout.Rows)
+	s.SeatsPerRow = 0
+	for _, row := range layout.Rows {
+		if len(row.Seats) > s.SeatsPerRow {
+			s.SeatsPerRow = len(row.Seats)
+		}
+	}
+
+	var released []SeatPosition
+	occupied := s.Occupied[:0]
+	for _, seat := range s.Occupied {
+		if layout.selectable(seat.Row, seat.Seat) {
+			occupied = append(occupied, seat)
+		} else {
+			released = append(released, seat)
+		}
+	}
+	s.Occupied = occupied
+	s.filterHolds(func(hold SeatHold) bool {
+		return layout.selectable(hold.Row, hold.Seat)
+	})
+	return released
+}
+
+// Check that a visitor can take a seat in a screening, returning the HTTP
+// status and error message to respond with if not. Must be called with mu held.
+func checkSeat(screening *Screening, row, position int, visitor *Visitor) (int, string) {
+	if visitor.ScreeningID != screening.ID {
+		return http.StatusForbidden, "You are not in this lobby"
+	}
+
+	if !screening.Seats.Layout.selectable(row, position) {
+		return http.StatusBadRequest, "Invalid seat"
+	}
+
+	for _, seat := range screening.Seats.Occupied {
+		if seat.Row == row && seat.Seat == position && seat.VisitorID != visitor.ID {
+			return http.StatusConflict, "Seat is already occupied"
+		}
+	}
+
+	screening.Seats.pruneHolds(time.Now())
+	if hold := screening.Seats.hold(row, position); hold != nil && !hold.heldFor(visitor) {
+		return http.StatusConflict, "Seat is being held"
+	}
+
+	if !rsvpAllowsSeat(screening, visitor, row, position) {
+		return http.StatusConflict, "The remaining seats are reserved for RSVPs"
+	}
+
+	return http.StatusOK, ""
+}
+
+// Get a theater's seat layout
+func getTheaterLayout(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	layout, exists := theaterLayouts[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Theater not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"layout":   layout,
+		"capacity": layout.capacity(),
+	})
+}
+
+// Replace a theater's seat layout, given as full rows or a compact plan
+func updateTheaterLayout(c *gin.Context) {
+	var request struct {
+		Rows []SeatRow `json:"rows"`
+		Plan []string  `json:"plan"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var layout *SeatLayout
+	var err error
+	if len(request.Plan) > 0 {
+		layout, err = layoutFromPlan(request.Plan)
+	} else {
+		layout = &SeatLayout{Rows: request.Rows}
+		err = layout.normalize()
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	theaterID := c.Param("id")
+	theaterLayouts[theaterID] = layout
+
+	// Apply to the theater's screenings, unseating visitors whose seat is gone
+	for screeningID, screening := range screenings {
+		if screening.TheaterID != theaterID {
+			continue
+		}
+		for _, seat := range screening.Seats.applyLayout(layout) {
+			if visitor, exists := visitors[seat.VisitorID]; exists {
+				visitor.Seat = nil
+			}
+		}
+		broadcastToScreening(screeningID, WebSocketMessage{
+			Type: "seat_update",
+			Data: screening.Seats,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"layout":   layout,
+		"capacity": layout.capacity(),
+	})
+}
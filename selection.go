@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sidecar files a screening can offer next to the feature
+var (
+	subtitleExtensions = map[string]bool{".srt": true, ".vtt": true, ".ass": true, ".ssa": true}
+	audioExtensions    = map[string]bool{
+		".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".oga": true, ".opus": true, ".flac": true, ".wav": true, ".mka": true,
+	}
+)
+
+// FileSelection is what a screening plays from a multi-file torrent, so
+// every visitor picks the same file
+type FileSelection struct {
+	Feature   SelectedFile   `json:"feature"`
+	Subtitles []SelectedFile `json:"subtitles"`
+	Audio     []SelectedFile `json:"audio"`
+}
+
+// SelectedFile is a file of the torrent by its index in the info dictionary
+type SelectedFile struct {
+	Index  int    `json:"index"`
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+// FileSelectionRequest picks files by index in the film's file list
+type FileSelectionRequest struct {
+	Feature   *int  `json:"feature_file"`
+	Subtitles []int `json:"subtitle_files"`
+	Audio     []int `json:"audio_files"`
+}
+
+// Pick the largest video as the feature when the film's files are known and
+// there's more than one to choose from
+func defaultFileSelection(film *Film) *FileSelection {
+	if film == nil || len(film.Files) < 2 {
+		return nil
+	}
+
+	feature := -1
+	for i, file := range film.Files {
+		if !videoExtensions[strings.ToLower(path.Ext(file.Path))] {
+			continue
+		}
+		if feature < 0 || file.Length > film.Files[feature].Length {
+			feature = i
+		}
+	}
+	if feature < 0 {
+		return nil
+	}
+	return &FileSelection{
+		Feature:   selectedFile(film, feature),
+		Subtitles: []SelectedFile{},
+		Audio:     []SelectedFile{},
+	}
+}
+
+func selectedFile(film *Film, index int) SelectedFile {
+	return SelectedFile{Index: index, Path: film.Files[index].Path, Length: film.Files[index].Length}
+}
+
+// Check a request against the film's file list. Files left out of the
+// request fall back to the default choice. Returns an error message on failure.
+func (r *FileSelectionRequest) resolve(film *Film) (*FileSelection, string) {
+	if r.Feature == nil && len(r.Subtitles) == 0 && len(r.Audio) == 0 {
+		return defaultFileSelection(film), ""
+	}
+	if film == nil || len(film.Files) == 0 {
+		return nil, "The film's file list is unknown; add it from a .torrent file first"
+	}
+
+	selection := defaultFileSelection(film)
+	if selection == nil {
+		selection = &FileSelection{Feature: selectedFile(film, 0)}
+	}
+	if r.Feature != nil {
+		if *r.Feature < 0 || *r.Feature >= len(film.Files) {
+			return nil, "Feature file index out of range"
+		}
+		selection.Feature = selectedFile(film, *r.Feature)
+	}
+
+	pick := func(indexes []int, extensions map[string]bool, kind string) ([]SelectedFile, string) {
+		files := []SelectedFile{}
+		seen := make(map[int]bool)
+		for _, index := range indexes {
+			if index < 0 || index >= len(film.Files) {
+				return nil, "File index " + strconv.Itoa(index) + " out of range"
+			}
+			if index == selection.Feature.Index || seen[index] {
+				continue
+			}
+			if !extensions[strings.ToLower(path.Ext(film.Files[index].Path))] {
+				return nil, film.Files[index].Path + " is not " + kind + " file"
+			}
+			seen[index] = true
+			files = append(files, selectedFile(film, index))
+		}
+		return files, ""
+	}
+
+	var message string
+	if selection.Subtitles, message = pick(r.Subtitles, subtitleExtensions, "a subtitle"); message != "" {
+		return nil, message
+	}
+	if selection.Audio, message = pick(r.Audio, audioExtensions, "an audio"); message != "" {
+		return nil, message
+	}
+	return selection, ""
+}
+
+// Indexes of the selected files for a magnet link's so= (select only) parameter
+func (s *FileSelection) selectOnly() string {
+	indexes := []string{strconv.Itoa(s.Feature.Index)}
+	for _, file := range s.Subtitles {
+		indexes = append(indexes, strconv.Itoa(file.Index))
+	}
+	for _, file := range s.Audio {
+		indexes = append(indexes, strconv.Itoa(file.Index))
+	}
+	return strings.Join(indexes, ",")
+}
+
+// Change the files every lobby of a schedule plays. Visitors already
+// watching are told so they can switch.
+func updateScheduleFiles(c *gin.Context) {
+	var request FileSelectionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	lobbies := scheduleLobbies(c.Param("id"), time.Now())
+	if len(lobbies) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+	film, exists := films[lobbies[0].FilmID]
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only screenings of catalog films have a file list"})
+		return
+	}
+
+	selection, message := request.resolve(film)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	for _, lobby := range lobbies {
+		lobby.Files = selection
+		broadcastToScreening(lobby.ID, WebSocketMessage{
+			Type: "file_selection",
+			Data: selection,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedule_id": c.Param("id"), "file_selection": selection})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Signal is a WebRTC offer, answer or ICE candidate relayed between visitors
+type Signal struct {
+	From    string      `json:"from"`
+	Target  string      `json:"target,omitempty"` // Empty to send an ICE candidate to everyone else in the screening
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+}
+
+// signalInbox queues signals for a visitor without a WebSocket, who
+// long-polls for them instead
+type signalInbox struct {
+	signals  []Signal
+	lastPoll time.Time
+	wake     chan struct{}
+}
+
+// Long-poll limits of the REST signaling fallback
+const (
+	signalInboxSize   = 200
+	signalPollDefault = 25 * time.Second
+	signalPollMax     = 30 * time.Second
+)
+
+var (
+	signalInboxes     = make(map[string]*signalInbox) // Visitor ID -> signals awaiting a poll
+	signalTypes       = map[string]bool{"offer": true, "answer": true, "ice-candidate": true}
+	errSignalType     = fmt.Errorf("invalid signal type")
+	errSignalPayload  = fmt.Errorf("signal payload is missing")
+	errSignalTarget   = fmt.Errorf("offers and answers need a target")
+	errSignalNoTarget = fmt.Errorf("target visitor not found")
+)
+
+// Whether a visitor polled for signals recently enough to still be
+// listening. Must be called with mu held.
+func (inbox *signalInbox) active(now time.Time) bool {
+	return now.Sub(inbox.lastPoll) <= 2*signalPollMax
+}
+
+// Deliver a signal to a visitor's WebSockets, or queue it for their next
+// poll when they have none. Returns false if the visitor can't be reached.
+// Must be called with mu held.
+func deliverSignal(visitorID string, signal Signal) bool {
+	for _, id := range clients {
+		if id == visitorID {
+			sendToVisitor(visitorID, WebSocketMessage{Type: "webrtc_signal", Data: signal})
+			return true
+		}
+	}
+
+	inbox, exists := signalInboxes[visitorID]
+	if !exists || !inbox.active(time.Now()) {
+		return false
+	}
+	inbox.signals = append(inbox.signals, signal)
+	if len(inbox.signals) > signalInboxSize {
+		inbox.signals = inbox.signals[len(inbox.signals)-signalInboxSize:]
+	}
+	select {
+	case inbox.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Relay a visitor's signal to another visitor of the same screening, or an
+// ICE candidate to all of them when no target is given. Must be called with mu held.
+func postSignal(visitor *Visitor, signal Signal) error {
+	if !signalTypes[signal.Type] {
+		return errSignalType
+	}
+	if signal.Payload == nil {
+		return errSignalPayload
+	}
+	if signal.Target == "" && signal.Type != "ice-candidate" {
+		return errSignalTarget
+	}
+	signal.From = visitor.ID
+	visitor.LastActive = time.Now()
+
+	if signal.Target != "" {
+		target, exists := visitors[signal.Target]
+		if !exists || target.ID == visitor.ID || target.ScreeningID != visitor.ScreeningID ||
+			!deliverSignal(target.ID, signal) {
+			return errSignalNoTarget
+		}
+		return nil
+	}
+
+	for _, target := range visitors {
+		if target.ID != visitor.ID && target.ScreeningID == visitor.ScreeningID {
+			deliverSignal(target.ID, signal)
+		}
+	}
+	return nil
+}
+
+// Relay a webrtc_signal WebSocket message. Must be called with mu held.
+func handleSignal(conn *websocket.Conn, visitor *Visitor, data interface{}) {
+	var signal Signal
+	if err := decodeMessageData(data, &signal); err != nil {
+		sendError(conn, "Invalid signal data")
+		return
+	}
+
+	if err := postSignal(visitor, signal); err != nil {
+		sendError(conn, err.Error())
+	}
+}
+
+// Verify the visitor token and that the visitor belongs to the screening in
+// the path. Writes the error response and returns nil otherwise. Must be
+// called with mu held.
+func signalingVisitor(c *gin.Context) *Visitor {
+	visitorID, err := verifyToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil
+	}
+
+	screeningID := c.Param("id")
+
+	// If screening doesn't exist, use default
+	if _, exists := screenings[screeningID]; !exists {
+		screeningID = "default"
+	}
+
+	visitor := visitors[visitorID]
+	if visitor.ScreeningID != screeningID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not a visitor of this screening"})
+		return nil
+	}
+	return visitor
+}
+
+// Send a WebRTC signal over REST, for visitors whose network blocks WebSockets
+func sendSignal(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	visitor := signalingVisitor(c)
+	if visitor == nil {
+		return
+	}
+
+	var request struct {
+		Type     string      `json:"type" binding:"required"`
+		Payload  interface{} `json:"payload"`
+		TargetID string      `json:"target_id"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err := postSignal(visitor, Signal{Target: request.TargetID, Type: request.Type, Payload: request.Payload})
+	if err == errSignalNoTarget {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target visitor not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Signal sent",
+	})
+}
+
+// Long-poll for signals sent to the visitor. Answers as soon as any are
+// queued, or with an empty list once the wait runs out.
+func pollSignals(c *gin.Context) {
+	wait := signalPollDefault
+	if value := c.Query("wait"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wait"})
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+		if wait > signalPollMax {
+			wait = signalPollMax
+		}
+	}
+
+	mu.Lock()
+	visitor := signalingVisitor(c)
+	if visitor == nil {
+		mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	inbox, exists := signalInboxes[visitor.ID]
+	if !exists {
+		inbox = &signalInbox{wake: make(chan struct{}, 1)}
+		signalInboxes[visitor.ID] = inbox
+	}
+	inbox.lastPoll = now
+	visitor.LastActive = now
+
+	if len(inbox.signals) == 0 && wait > 0 {
+		mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-inbox.wake:
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+			// Nobody is left to read the answer, so keep the signals for the next poll
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+		mu.Lock()
+	}
+
+	signals := inbox.signals
+	if signals == nil {
+		signals = []Signal{}
+	}
+	inbox.signals = nil
+	inbox.lastPoll = time.Now()
+	mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"signals": signals})
+}
@@ -118,27 +118,104 @@ document.addEventListener('DOMContentLoaded', () => {
         return `${adjective}${noun}`;
     }
     
-    // Automatically join the default screening
+    // Count the leading zero bits of a hash
+    function leadingZeroBits(bytes) {
+        let count = 0;
+        for (const byte of bytes) {
+            if (byte === 0) {
+                count += 8;
+                continue;
+            }
+            return count + Math.clz32(byte) - 24;
+        }
+        return count;
+    }
+    
+    // Fetch and solve the server's proof-of-work challenge, if it requires one
+    async function solveAdmissionChallenge() {
+        const response = await fetch('/api/auth/challenge');
+        if (!response.ok) {
+            throw new Error(`Failed to get admission challenge (${response.status})`);
+        }
+        
+        const challenge = await response.json();
+        if (!challenge.required) {
+            return {};
+        }
+        
+        console.log("Solving admission challenge with difficulty", challenge.difficulty);
+        const encoder = new TextEncoder();
+        const started = Date.now();
+        for (let counter = 0; ; counter++) {
+            const solution = counter.toString(36);
+            const digest = await crypto.subtle.digest('SHA-256', encoder.encode(challenge.nonce + solution));
+            if (leadingZeroBits(new Uint8Array(digest)) >= challenge.difficulty) {
+                console.log("Admission challenge solved in", Date.now() - started, "ms");
+                return { challenge_id: challenge.challenge_id, solution };
+            }
+        }
+    }
+    
+    // Request a visitor token, asking for a passcode if the screening is private
+    async function requestVisitorToken(screeningId, visitorName, admission) {
+        const params = new URLSearchParams(window.location.search);
+        const body = {
+            screening_id: screeningId,
+            visitor_name: visitorName,
+            invite: params.get('invite') || undefined,
+            ...admission
+        };
+        
+        let response = await fetch('/api/auth/visitor', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify(body)
+        });
+        
+        if (response.status === 403) {
+            const error = await response.clone().json().catch(() => ({}));
+            if (error.passcode_required) {
+                body.passcode = prompt("This screening is private. Enter the passcode:") || '';
+                if (admission.challenge_id) {
+                    Object.assign(body, await solveAdmissionChallenge());
+                }
+                response = await fetch('/api/auth/visitor', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify(body)
+                });
+            }
+        }
+        
+        return response;
+    }
+    
+    // Show our place in the admission queue
+    window.updateQueuePosition = function(queue) {
+        document.getElementById('loading-status').textContent =
+            `Every lobby is full. You are number ${queue.position} in line...`;
+    };
+    
+    // Automatically join the screening from the link, or the default one
     async function autoJoinScreening() {
         try {
             console.log("Starting auto-join process...");
+            const screeningId = new URLSearchParams(window.location.search).get('screening') || 'default';
             
             const visitorName = generateMovieName();
             console.log("Generated visitor name:", visitorName);
             userNameElement.textContent = visitorName;
             
+            // Prove we're not a bot if the server asks
+            const admission = await solveAdmissionChallenge();
+            
             // Get visitor token from server
             console.log("Requesting visitor token from server...");
-            const response = await fetch('/api/auth/visitor', {
-                method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json'
-                },
-                body: JSON.stringify({
-                    screening_id: 'default',
-                    visitor_name: visitorName
-                })
-            });
+            const response = await requestVisitorToken(screeningId, visitorName, admission);
             
             if (!response.ok) {
                 const errorText = await response.text();
@@ -148,18 +225,24 @@ document.addEventListener('DOMContentLoaded', () => {
             
             const data = await response.json();
             console.log("Received authentication response:", data);
-            const visitorToken = data.token;
             
-            // Initialize P2P communication
+            // Initialize P2P communication in the lobby the server placed us in
             console.log("Initializing P2P communication...");
-            p2p = new VirtualplexP2P('default', visitorToken);
+            p2p = new VirtualplexP2P(data.screening_id || screeningId, data.token);
             await p2p.initialize(videoElement);
             
+            // Every lobby is full: wait in line until a place opens up
+            if (data.queued) {
+                window.updateQueuePosition({ position: data.queue_position });
+                await p2p.waitForAdmission();
+                console.log("Admitted to lobby", p2p.screeningId);
+            }
+            
             // Get screening details
             console.log("Fetching screening details...");
-            const screeningResponse = await fetch('/api/screenings/default', {
+            const screeningResponse = await fetch(`/api/screenings/${encodeURIComponent(p2p.screeningId)}`, {
                 headers: {
-                    'Authorization': `Bearer ${visitorToken}`
+                    'Authorization': `Bearer ${p2p.visitorToken}`
                 }
             });
             
@@ -180,11 +263,26 @@ document.addEventListener('DOMContentLoaded', () => {
             console.log("Rendering seats...");
             renderSeats(screeningData.seats);
             
+            // Sit with friends when arriving through a party invite link
+            const partyCode = new URLSearchParams(window.location.search).get('party');
+            if (partyCode) {
+                try {
+                    const party = await p2p.joinParty(partyCode);
+                    console.log("Joined party, seated at", party.seat);
+                    currentSeat = seatsGrid.querySelector(`.seat[data-row="${party.seat.row}"][data-seat="${party.seat.seat}"]`);
+                    if (currentSeat) {
+                        currentSeat.classList.add('selected');
+                    }
+                } catch (partyError) {
+                    console.warn("Could not join party:", partyError);
+                }
+            }
+            
             // Start movie streaming
             if (screeningData.magnet_link) {
                 console.log("Starting movie streaming with magnet link:", screeningData.magnet_link);
                 try {
-                    await p2p.startStreaming(screeningData.magnet_link);
+                    await p2p.startStreaming(screeningData.magnet_link, screeningData.file_selection, screeningData.subtitles);
                     console.log("Movie streaming started successfully");
                 } catch (streamingError) {
                     console.error("Failed to start streaming:", streamingError);
@@ -202,34 +300,50 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
     
-    // Render the seats grid
+    // Render the seats grid from the theater's layout
     function renderSeats(seatsData) {
         console.log("Rendering seats grid with data:", seatsData);
         seatsGrid.innerHTML = '';
+        seatsGrid.style.gridTemplateColumns = `repeat(${seatsData.seats_per_row}, 1fr)`;
         
-        const rows = seatsData.rows;
-        const seatsPerRow = seatsData.seats_per_row;
-        
-        for (let row = 0; row < rows; row++) {
-            for (let seat = 0; seat < seatsPerRow; seat++) {
+        seatsData.layout.rows.forEach((layoutRow, row) => {
+            for (let seat = 0; seat < seatsData.seats_per_row; seat++) {
+                const layoutSeat = layoutRow.seats[seat];
+                
+                // Aisles and the space after short rows are empty cells
+                if (!layoutSeat || layoutSeat.type === 'aisle') {
+                    const gapElement = document.createElement('div');
+                    gapElement.className = 'aisle';
+                    seatsGrid.appendChild(gapElement);
+                    continue;
+                }
+                
                 const seatElement = document.createElement('div');
-                seatElement.className = 'seat';
+                seatElement.className = `seat ${layoutSeat.type}`;
                 seatElement.dataset.row = row;
                 seatElement.dataset.seat = seat;
-                seatElement.textContent = `${String.fromCharCode(65 + row)}${seat + 1}`;
+                seatElement.textContent = layoutSeat.label;
+                seatElement.title = layoutSeat.type === 'standard' ? layoutSeat.label : `${layoutSeat.label} (${layoutSeat.type})`;
                 
-                // Check if seat is occupied
+                // Check if seat is occupied or held
                 const occupied = seatsData.occupied.some(s => s.row === row && s.seat === seat);
                 if (occupied) {
                     console.log(`Seat ${row}:${seat} is occupied`);
                     seatElement.classList.add('occupied');
-                } else {
-                    seatElement.addEventListener('click', () => selectSeat(row, seat, seatElement));
+                } else if ((seatsData.held || []).some(s => s.row === row && s.seat === seat)) {
+                    seatElement.classList.add('held');
+                }
+                if (layoutSeat.type !== 'blocked') {
+                    seatElement.addEventListener('click', () => {
+                        if (!seatElement.classList.contains('occupied')) {
+                            selectSeat(row, seat, seatElement);
+                        }
+                    });
                 }
                 
                 seatsGrid.appendChild(seatElement);
             }
-        }
+        });
     }
     
     // Select a seat
@@ -294,7 +408,7 @@ document.addEventListener('DOMContentLoaded', () => {
             
             const senderElement = document.createElement('div');
             senderElement.className = 'sender';
-            senderElement.textContent = msg.from === p2p.visitorId ? 'You' : `Visitor ${msg.from.substring(0, 6)}`;
+            senderElement.textContent = msg.from === p2p.visitorId ? 'You' : (msg.name || `Visitor ${msg.from.substring(0, 6)}`);
             
             const textElement = document.createElement('div');
             textElement.className = 'text';
@@ -327,9 +441,29 @@ document.addEventListener('DOMContentLoaded', () => {
         });
     };
     
+    // Show operator intermissions on the screen label
+    window.updateProjection = (event) => {
+        const screenLabel = document.querySelector('.screen-label');
+        if (event.action === 'intermission') {
+            const until = new Date(event.intermission_until).toLocaleTimeString();
+            screenLabel.textContent = `Intermission until ${until}${event.message ? ' - ' + event.message : ''}`;
+        } else if (event.action === 'pause') {
+            screenLabel.textContent = 'Paused by the projectionist';
+        } else {
+            screenLabel.textContent = 'Screen';
+        }
+    };
+    
     // Update seat information UI
     window.updateSeatInformation = (seatData) => {
         console.log("Updating seat information:", seatData);
+        
+        // Operators can change the layout while visitors are seated
+        if (seatData.layout && seatsGrid.querySelectorAll('.seat, .aisle').length !==
+            seatData.layout.rows.length * seatData.seats_per_row) {
+            renderSeats(seatData);
+            return;
+        }
         // Update the seats grid with new occupancy data
         const allSeats = seatsGrid.querySelectorAll('.seat');
         
@@ -338,7 +472,7 @@ document.addEventListener('DOMContentLoaded', () => {
             const seatNum = parseInt(seat.dataset.seat);
             
             // Reset state
-            seat.classList.remove('occupied');
+            seat.classList.remove('occupied', 'held');
             if (currentSeat !== seat) {
                 seat.classList.remove('selected');
             }
@@ -347,6 +481,8 @@ document.addEventListener('DOMContentLoaded', () => {
             const occupied = seatData.occupied.some(s => s.row === row && s.seat === seatNum);
             if (occupied) {
                 seat.classList.add('occupied');
+            } else if ((seatData.held || []).some(s => s.row === row && s.seat === seatNum)) {
+                seat.classList.add('held');
             }
         });
     };
@@ -21,8 +21,10 @@ class VirtualplexP2P {
       }
     }
     
-    // WebSocket connection for signaling
+    // WebSocket connection for signaling, and whether signals are being
+    // long-polled over REST instead
     this.socket = null;
+    this.signalPolling = false;
     
     // WebRTC connections to other visitors
     this.peers = {};
@@ -37,6 +39,18 @@ class VirtualplexP2P {
     // Chat history
     this.chatMessages = [];
     
+    // ICE servers from the server, shared by WebTorrent and our own peer
+    // connections; filled in and updated in place when TURN credentials are
+    // renewed
+    this.rtcConfig = {
+      iceServers: []
+    };
+    
+    // Server clock estimate (server time minus local time) and the latest playback state
+    this.clockOffset = 0;
+    this.bestRoundTrip = Infinity;
+    this.playbackState = null;
+    
     console.log("VirtualplexP2P instance created for screening:", screeningId);
   }
   
@@ -53,13 +67,18 @@ class VirtualplexP2P {
       await this.connectSignaling();
       console.log("WebSocket connection established successfully");
     } catch (error) {
-      console.error("Failed to connect to WebSocket:", error);
-      throw error;
+      // Some networks strip WebSocket upgrades; signal over REST until a
+      // reconnect attempt gets through
+      console.error("Failed to connect to WebSocket, falling back to REST signaling:", error);
+      this.pollSignals();
     }
     
     // Setup heartbeat to keep seat reservation active
     this.startHeartbeat();
     
+    // Fetch STUN/TURN servers before any peer connection is made
+    await this.fetchIceServers();
+    
     // Listen for window close/refresh to clean up
     window.addEventListener('beforeunload', () => this.cleanup());
     
@@ -88,6 +107,7 @@ class VirtualplexP2P {
         }));
         
         console.log('Connected to signaling server');
+        this.signalPolling = false;
         resolve();
       };
       
@@ -98,8 +118,10 @@ class VirtualplexP2P {
       
       this.socket.onclose = (event) => {
         console.log('Disconnected from signaling server', event.code, event.reason);
-        // Try to reconnect after a delay
-        setTimeout(() => this.connectSignaling(), 5000);
+        // Try to reconnect after a delay, unless a moderator removed us
+        if (!this.removed) {
+          setTimeout(() => this.connectSignaling(), 5000);
+        }
       };
       
       this.socket.onmessage = (event) => {
@@ -148,6 +170,31 @@ class VirtualplexP2P {
         }
         break;
         
+      case 'position_batch':
+        // Apply relayed positions of nearby visitors
+        message.data.updates.forEach(update => {
+          this.visitorPositions[update.visitor_id] = {
+            position: update.position,
+            rotation: update.rotation,
+            room: update.room
+          };
+        });
+        (message.data.removed || []).forEach(visitorId => {
+          delete this.visitorPositions[visitorId];
+        });
+        if (typeof window.updateVisitorPositions === 'function') {
+          window.updateVisitorPositions(this.visitorPositions);
+        }
+        break;
+        
+      case 'file_selection':
+        // The operator picked another file; switch if the torrent is loaded
+        this.fileSelection = message.data;
+        if (this.torrent) {
+          this.renderSelectedFile();
+        }
+        break;
+        
       case 'screening_status':
         // Update screening status (e.g., ending soon)
         console.log('Screening status update', message.data);
@@ -158,6 +205,87 @@ class VirtualplexP2P {
         
       case 'authenticated':
         console.log('WebSocket authenticated:', message.data);
+        if (message.data.swarm_report_interval) {
+          this.swarmReportInterval = message.data.swarm_report_interval * 1000;
+        }
+        this.startClockSync();
+        break;
+        
+      case 'time_sync':
+        this.handleTimeSync(message.data);
+        break;
+        
+      case 'playback_sync':
+        this.playbackState = message.data;
+        this.applyPlaybackSync();
+        break;
+        
+      case 'chat_history':
+        // Replace local history with the server's, e.g. after a reconnect
+        this.chatMessages = [];
+        message.data.messages.forEach(chat => this.addChatMessage(chat));
+        if (typeof window.updateChat === 'function') {
+          window.updateChat(this.chatMessages);
+        }
+        break;
+        
+      case 'chat_message':
+        this.addChatMessage(message.data);
+        if (typeof window.updateChat === 'function') {
+          window.updateChat(this.chatMessages);
+        }
+        break;
+        
+      case 'chat_deleted':
+        // A moderator removed a message
+        this.chatMessages = this.chatMessages.filter(chat => chat.id !== message.data.message_id);
+        if (typeof window.updateChat === 'function') {
+          window.updateChat(this.chatMessages);
+        }
+        break;
+        
+      case 'muted':
+        console.warn('Muted until', message.data.until, message.data.reason);
+        alert(`You have been muted until ${new Date(message.data.until).toLocaleTimeString()}.`);
+        break;
+        
+      case 'kicked':
+      case 'banned':
+        // Don't reconnect after being removed by a moderator
+        console.warn('Removed from screening:', message.type, message.data.reason);
+        this.removed = true;
+        alert(`You have been ${message.type} from this screening.${message.data.reason ? ' Reason: ' + message.data.reason : ''}`);
+        break;
+        
+      case 'queue_position':
+        // Still waiting for a place in a lobby
+        if (typeof window.updateQueuePosition === 'function') {
+          window.updateQueuePosition(message.data);
+        }
+        break;
+        
+      case 'admitted':
+        // A place opened up: move to the lobby with its token
+        this.admit(message.data.screening_id, message.data.token);
+        break;
+        
+      case 'queue_closed':
+        this.removed = true;
+        alert('The screening has ended before a place opened up.');
+        break;
+        
+      case 'room_closed':
+        // Watch party ended; there's nothing to reconnect to
+        this.removed = true;
+        alert('This watch party has ended.');
+        break;
+        
+      case 'projection_event':
+        // Operator paused, resumed, seeked or started an intermission
+        console.log('Projection event', message.data);
+        if (typeof window.updateProjection === 'function') {
+          window.updateProjection(message.data);
+        }
         break;
         
       case 'error':
@@ -180,16 +308,11 @@ class VirtualplexP2P {
     
     console.log('Initiating peer connection with', targetVisitorId);
     
-    const peerConnection = new RTCPeerConnection({
-      iceServers: [
-        { urls: 'stun:stun.l.google.com:19302' },
-        { urls: 'stun:stun1.l.google.com:19302' }
-      ]
-    });
+    const peerConnection = new RTCPeerConnection(this.rtcConfig);
     
     this.peers[targetVisitorId] = peerConnection;
     
-    // Setup data channel for chat and position updates
+    // Setup data channel
     const dataChannel = peerConnection.createDataChannel('virtuaplex_data');
     this.setupDataChannel(dataChannel, targetVisitorId);
     
@@ -221,7 +344,7 @@ class VirtualplexP2P {
   }
   
   /**
-   * Set up a WebRTC data channel for chat and position updates
+   * Set up a WebRTC data channel
    */
   setupDataChannel(dataChannel, peerId) {
     dataChannel.onopen = () => {
@@ -234,43 +357,6 @@ class VirtualplexP2P {
     
     dataChannel.onmessage = (event) => {
       console.log('Received data channel message from', peerId);
-      const message = JSON.parse(event.data);
-      
-      switch (message.type) {
-        case 'chat':
-          // Handle chat message
-          console.log('Received chat message from', peerId);
-          this.chatMessages.push({
-            from: peerId,
-            text: message.text,
-            timestamp: new Date()
-          });
-          if (typeof window.updateChat === 'function') {
-            window.updateChat(this.chatMessages);
-          }
-          break;
-          
-        case 'position':
-          // Handle position update
-          this.visitorPositions[peerId] = message.position;
-          if (typeof window.updateVisitorPositions === 'function') {
-            window.updateVisitorPositions(this.visitorPositions);
-          }
-          break;
-          
-        case 'playback':
-          // Handle playback sync
-          console.log('Received playback sync from', peerId, message);
-          if (this.videoElement && Math.abs(this.videoElement.currentTime - message.position) > 5) {
-            this.videoElement.currentTime = message.position;
-            if (message.playing && this.videoElement.paused) {
-              this.videoElement.play();
-            } else if (!message.playing && !this.videoElement.paused) {
-              this.videoElement.pause();
-            }
-          }
-          break;
-      }
     };
     
     // Store the data channel
@@ -345,7 +431,52 @@ class VirtualplexP2P {
         }
       }));
     } else {
-      console.error('Cannot send signal, WebSocket not open');
+      console.log('Sending signal over REST:', type, 'to:', targetId);
+      fetch(`/api/screenings/${this.screeningId}/signal`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          'Authorization': `Bearer ${this.visitorToken}`
+        },
+        body: JSON.stringify({
+          type: type,
+          payload: payload,
+          target_id: targetId
+        })
+      })
+      .then(response => response.json())
+      .then(data => {
+        if (data.error) {
+          console.error('Error sending signal:', data.error);
+        }
+      })
+      .catch(error => console.error('Error sending signal', error));
+    }
+  }
+  
+  /**
+   * Long-poll for WebRTC signals while the WebSocket is unavailable
+   */
+  async pollSignals() {
+    if (this.signalPolling) {
+      return;
+    }
+    this.signalPolling = true;
+    
+    while (this.signalPolling) {
+      try {
+        const response = await fetch(`/api/screenings/${this.screeningId}/signal?wait=25`, {
+          headers: { 'Authorization': `Bearer ${this.visitorToken}` }
+        });
+        const data = await response.json();
+        if (!response.ok) {
+          throw new Error(data.error || `HTTP error ${response.status}`);
+        }
+        data.signals.forEach(signal => this.handleWebRTCSignal(signal));
+      } catch (error) {
+        console.error('Error polling for signals', error);
+        await new Promise(resolve => setTimeout(resolve, 5000));
+      }
     }
   }
   
@@ -381,6 +512,124 @@ class VirtualplexP2P {
     });
   }
   
+  /**
+   * POST to a seat endpoint of this screening and return the JSON response
+   */
+  seatRequest(path, body) {
+    return fetch(`/api/screenings/${this.screeningId}/${path}`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        'Authorization': `Bearer ${this.visitorToken}`
+      },
+      body: JSON.stringify(body || {})
+    })
+    .then(async response => {
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || `HTTP error ${response.status}`);
+      }
+      return data;
+    });
+  }
+  
+  /**
+   * Get ICE servers with short-lived TURN credentials, renewing them before
+   * they expire. Keeps the current servers if the request fails.
+   */
+  async fetchIceServers() {
+    if (this.iceRefreshTimer) {
+      clearTimeout(this.iceRefreshTimer);
+    }
+    try {
+      const response = await fetch('/api/ice-servers', {
+        headers: { 'Authorization': `Bearer ${this.visitorToken}` }
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || `HTTP error ${response.status}`);
+      }
+      this.rtcConfig.iceServers = data.ice_servers;
+      console.log('ICE servers:', data.ice_servers.map(server => server.urls));
+      
+      if (data.ttl > 60) {
+        this.iceRefreshTimer = setTimeout(() => this.fetchIceServers(), data.ttl * 800);
+      }
+    } catch (error) {
+      console.warn('Could not fetch ICE servers, using defaults:', error);
+    }
+  }
+  
+  /**
+   * Resolve once the server admits us from the queue
+   */
+  waitForAdmission() {
+    return new Promise(resolve => {
+      this.onAdmitted = resolve;
+    });
+  }
+  
+  /**
+   * Switch to the lobby we were admitted to and reconnect there
+   */
+  admit(screeningId, token) {
+    this.screeningId = screeningId;
+    this.visitorToken = token;
+    
+    // The old socket's close handler reconnects using the new lobby and token
+    if (this.socket) {
+      this.socket.close();
+    }
+    
+    if (this.onAdmitted) {
+      this.onAdmitted();
+      this.onAdmitted = null;
+    }
+  }
+  
+  /**
+   * Hold a seat for a short time while deciding
+   */
+  holdSeat(row, seat) {
+    return this.seatRequest('seats/hold', { row_number: row, seat_number: seat });
+  }
+  
+  /**
+   * Reserve a block of adjacent seats for a party; the response has the invite code
+   */
+  reserveGroupSeats(count, preferredRow) {
+    return this.seatRequest('seats/group', { count, row_number: preferredRow });
+  }
+  
+  /**
+   * Join a friend's party and take a seat in its block
+   */
+  joinParty(code) {
+    return this.seatRequest(`parties/${encodeURIComponent(code)}/join`);
+  }
+  
+  /**
+   * Start a watch party room from a catalog film. The response carries a
+   * token for the room and a link to share with friends.
+   */
+  createRoom(filmId) {
+    return fetch('/api/rooms', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        'Authorization': `Bearer ${this.visitorToken}`
+      },
+      body: JSON.stringify({ film_id: filmId })
+    })
+    .then(async response => {
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || `HTTP error ${response.status}`);
+      }
+      return data;
+    });
+  }
+  
   /**
    * Release the currently selected seat
    */
@@ -411,10 +660,14 @@ class VirtualplexP2P {
   // Update the startStreaming method in p2p-communication.js
 
 /**
- * Start the WebTorrent streaming
+ * Start the WebTorrent streaming. The server's file selection, when there
+ * is one, says which file of a multi-file torrent everyone plays; subtitle
+ * tracks are WebVTT files the server converted for the film.
  */
-startStreaming(magnetLink) {
+startStreaming(magnetLink, fileSelection, subtitleTracks) {
   console.log('Starting WebTorrent streaming with magnet link:', magnetLink);
+  this.fileSelection = fileSelection || null;
+  this.subtitleTracks = subtitleTracks || [];
   
   // Use the globally available WebTorrent object instead of require
   if (typeof WebTorrent === 'undefined') {
@@ -428,14 +681,7 @@ startStreaming(magnetLink) {
     maxConns: 100,       // Max number of connections per torrent
     tracker: {
       announce: [], // Use default trackers in the magnet link
-      rtcConfig: {
-        iceServers: [
-          { urls: 'stun:stun.l.google.com:19302' },
-          { urls: 'stun:stun1.l.google.com:19302' },
-          { urls: 'stun:stun2.l.google.com:19302' },
-          { urls: 'stun:stun3.l.google.com:19302' }
-        ]
-      }
+      rtcConfig: this.rtcConfig
     }
   });
   
@@ -478,12 +724,9 @@ startStreaming(magnetLink) {
           return;
         }
         
-        const file = torrent.files.reduce((prev, current) => {
-          console.log('File:', current.name, 'Size:', current.length);
-          return (prev.length > current.length) ? prev : current;
-        });
+        const file = this.selectFiles(torrent);
         
-        console.log('Selected largest file for playback:', file.name, 'Size:', file.length);
+        console.log('Selected file for playback:', file.name, 'Size:', file.length);
         
         // Stream to video element
         console.log('Rendering file to video element...');
@@ -498,25 +741,15 @@ startStreaming(magnetLink) {
           }
           
           console.log('File rendered to video element successfully');
+          this.addSubtitleTracks(torrent);
+          this.startSwarmReports(torrent);
           
-          // Handle playback events for synchronization
-          this.videoElement.addEventListener('play', () => {
-            console.log('Video played at time:', this.videoElement.currentTime);
-            this.broadcastPlaybackState();
-          });
-          
-          this.videoElement.addEventListener('pause', () => {
-            console.log('Video paused at time:', this.videoElement.currentTime);
-            this.broadcastPlaybackState();
-          });
-          
-          this.videoElement.addEventListener('seeking', () => {
-            console.log('Video seeking to', this.videoElement.currentTime);
-            this.broadcastPlaybackState();
-          });
+          // Follow the server's playback clock once the file can play
+          this.applyPlaybackSync();
           
           this.videoElement.addEventListener('canplay', () => {
             console.log('Video can play now');
+            this.applyPlaybackSync();
           });
           
           this.videoElement.addEventListener('error', (e) => {
@@ -579,74 +812,230 @@ startStreaming(magnetLink) {
 }
   
   /**
-   * Broadcast the current playback state to all peers
+   * Find the feature file and download only it and its sidecars. Without a
+   * server selection the largest file is played, as before.
    */
-  broadcastPlaybackState() {
-    if (!this.videoElement) return;
-    
-    const playbackInfo = {
-      type: 'playback',
-      position: this.videoElement.currentTime,
-      playing: !this.videoElement.paused
-    };
+  selectFiles(torrent) {
+    torrent.files.forEach(file => console.log('File:', file.path, 'Size:', file.length));
     
-    console.log('Broadcasting playback state:', playbackInfo);
+    const selection = this.fileSelection;
+    const feature = selection && torrent.files[selection.feature.index];
+    if (!feature || feature.path !== selection.feature.path) {
+      if (selection) {
+        console.warn('Selected file not found in torrent, falling back to the largest file');
+      }
+      this.selectedFile = torrent.files.reduce((prev, current) => (prev.length > current.length) ? prev : current);
+      return this.selectedFile;
+    }
     
-    Object.values(this.peers).forEach(peer => {
-      if (peer.dataChannel && peer.dataChannel.readyState === 'open') {
-        peer.dataChannel.send(JSON.stringify(playbackInfo));
+    torrent.deselect(0, torrent.pieces.length - 1, false);
+    const sidecars = selection.subtitles.concat(selection.audio);
+    torrent.files.forEach((file, index) => {
+      if (index === selection.feature.index || sidecars.some(sidecar => sidecar.index === index)) {
+        file.select();
+      } else {
+        file.deselect();
       }
     });
+    this.selectedFile = feature;
+    return feature;
   }
   
   /**
-   * Send a chat message to all peers
+   * Switch to a newly selected file, keeping the server's playback position
    */
-  sendChat(text) {
-    const chatMessage = {
-      type: 'chat',
-      text: text,
-      timestamp: new Date()
-    };
+  renderSelectedFile() {
+    const file = this.selectFiles(this.torrent);
+    file.renderTo(this.videoElement, { autoplay: false, controls: true }, err => {
+      if (err) {
+        console.error('Error rendering file to video element:', err);
+        return;
+      }
+      this.addSubtitleTracks(this.torrent);
+      this.applyPlaybackSync();
+    });
+  }
+  
+  /**
+   * Report peers, transfer rates, download progress and playback stalls so
+   * operators can see a show buffering before viewers complain
+   */
+  startSwarmReports(torrent) {
+    if (this.swarmReportTimer) return;
     
-    console.log('Sending chat message:', text);
+    let stalls = 0;
+    this.videoElement.addEventListener('waiting', () => {
+      if (!this.videoElement.paused) stalls++;
+    });
     
-    // Add to local chat history
-    this.chatMessages.push({
-      from: this.visitorId,
-      text: text,
-      timestamp: new Date()
+    this.swarmReportTimer = setInterval(() => {
+      if (!this.socket || this.socket.readyState !== WebSocket.OPEN) return;
+      
+      const feature = this.selectedFile || torrent;
+      this.socket.send(JSON.stringify({
+        type: 'swarm_stats',
+        data: {
+          peers: torrent.numPeers,
+          download_rate: torrent.downloadSpeed,
+          upload_rate: torrent.uploadSpeed,
+          buffered: feature.progress * 100,
+          stalls: stalls
+        }
+      }));
+      stalls = 0;
+    }, this.swarmReportInterval || 10000);
+  }
+  
+  /**
+   * Attach the server's subtitle tracks and the selected WebVTT sidecars
+   * from the torrent as text tracks
+   */
+  addSubtitleTracks(torrent) {
+    this.videoElement.querySelectorAll('track[data-torrent], track[data-server]').forEach(track => track.remove());
+    
+    (this.subtitleTracks || []).forEach(subtitle => {
+      const track = document.createElement('track');
+      track.kind = 'subtitles';
+      track.label = subtitle.label;
+      track.srclang = subtitle.language;
+      track.src = subtitle.url;
+      track.dataset.server = 'true';
+      this.videoElement.appendChild(track);
     });
     
-    if (typeof window.updateChat === 'function') {
-      window.updateChat(this.chatMessages);
+    if (!this.fileSelection) return;
+    
+    this.fileSelection.subtitles
+      .filter(subtitle => subtitle.path.toLowerCase().endsWith('.vtt'))
+      .forEach(subtitle => {
+        const file = torrent.files[subtitle.index];
+        if (!file) return;
+        file.getBlobURL((err, url) => {
+          if (err) {
+            console.error('Error loading subtitles:', err);
+            return;
+          }
+          const track = document.createElement('track');
+          track.kind = 'subtitles';
+          track.label = file.name;
+          track.src = url;
+          track.dataset.torrent = 'true';
+          this.videoElement.appendChild(track);
+        });
+      });
+  }
+  
+  /**
+   * Probe the server clock a few times; the probe with the lowest
+   * round trip gives the most accurate offset, as in NTP
+   */
+  startClockSync() {
+    this.bestRoundTrip = Infinity;
+    for (let i = 0; i < 5; i++) {
+      setTimeout(() => {
+        if (this.socket && this.socket.readyState === WebSocket.OPEN) {
+          this.socket.send(JSON.stringify({
+            type: 'time_sync',
+            data: { client_time: Date.now() }
+          }));
+        }
+      }, i * 500);
+    }
+  }
+  
+  /**
+   * Update the clock offset from a time_sync reply
+   */
+  handleTimeSync(data) {
+    const now = Date.now();
+    const roundTrip = (now - data.client_time) - (data.transmit_time - data.receive_time);
+    if (roundTrip < this.bestRoundTrip) {
+      this.bestRoundTrip = roundTrip;
+      this.clockOffset = ((data.receive_time - data.client_time) + (data.transmit_time - now)) / 2;
+      console.log('Clock offset:', this.clockOffset, 'ms, round trip:', roundTrip, 'ms');
+    }
+  }
+  
+  /**
+   * Move the local video to where the server says the screening is
+   */
+  applyPlaybackSync() {
+    const state = this.playbackState;
+    if (!state || !this.videoElement || this.videoElement.readyState === 0) return;
+    
+    let target = state.position;
+    if (!state.paused) {
+      const serverNow = Date.now() + this.clockOffset;
+      target += (serverNow - state.server_time) / 1000;
     }
     
-    // Send to all peers
-    Object.values(this.peers).forEach(peer => {
-      if (peer.dataChannel && peer.dataChannel.readyState === 'open') {
-        peer.dataChannel.send(JSON.stringify(chatMessage));
-      }
-    });
+    // Before the screening starts, hold on the first frame
+    if (target < 0) {
+      this.videoElement.pause();
+      this.videoElement.currentTime = 0;
+      return;
+    }
+    
+    const drift = this.videoElement.currentTime - target;
+    if (Math.abs(drift) > 1) {
+      console.log('Seeking to server position', target.toFixed(2), 'drift', drift.toFixed(2));
+      this.videoElement.currentTime = target;
+      this.videoElement.playbackRate = 1;
+    } else if (Math.abs(drift) > 0.25) {
+      // Nudge the rate to catch up or fall back without a visible jump
+      this.videoElement.playbackRate = drift > 0 ? 0.95 : 1.05;
+    } else {
+      this.videoElement.playbackRate = 1;
+    }
+    
+    if (state.paused && !this.videoElement.paused) {
+      this.videoElement.pause();
+    } else if (!state.paused && this.videoElement.paused) {
+      this.videoElement.play().catch(error => console.warn('Autoplay blocked:', error));
+    }
   }
   
   /**
-   * Update and broadcast the visitor's position
+   * Send a chat message through the server, which stores and broadcasts it
    */
-  updatePosition(position, rotation) {
-    const positionUpdate = {
-      type: 'position',
-      position: { position, rotation }
-    };
+  sendChat(text) {
+    console.log('Sending chat message:', text);
     
-    // Send to all peers
-    Object.values(this.peers).forEach(peer => {
-      if (peer.dataChannel && peer.dataChannel.readyState === 'open') {
-        peer.dataChannel.send(JSON.stringify(positionUpdate));
-      }
+    if (this.socket && this.socket.readyState === WebSocket.OPEN) {
+      this.socket.send(JSON.stringify({
+        type: 'chat',
+        data: { text }
+      }));
+    } else {
+      console.error('Cannot send chat, WebSocket not open');
+    }
+  }
+  
+  /**
+   * Convert a server chat message for the chat UI
+   */
+  addChatMessage(message) {
+    this.chatMessages.push({
+      id: message.id,
+      from: message.visitor_id,
+      name: message.visitor_name,
+      text: message.text,
+      timestamp: message.timestamp
     });
   }
   
+  /**
+   * Send the visitor's position to the server, which relays it to nearby visitors
+   */
+  updatePosition(position, rotation, room) {
+    if (this.socket && this.socket.readyState === WebSocket.OPEN) {
+      this.socket.send(JSON.stringify({
+        type: 'position_update',
+        data: { position, rotation, room }
+      }));
+    }
+  }
+  
   /**
    * Start heartbeat to keep the seat reservation active
    */
@@ -691,6 +1080,9 @@ startStreaming(magnetLink) {
       }
     });
     
+    // Stop polling for signals
+    this.signalPolling = false;
+    
     // Close WebSocket
     if (this.socket) {
       this.socket.close();
@@ -706,6 +1098,16 @@ startStreaming(magnetLink) {
       clearInterval(this.heartbeatInterval);
     }
     
+    // Stop swarm reports
+    if (this.swarmReportTimer) {
+      clearInterval(this.swarmReportTimer);
+    }
+    
+    // Stop renewing TURN credentials
+    if (this.iceRefreshTimer) {
+      clearTimeout(this.iceRefreshTimer);
+    }
+    
     console.log('Cleanup completed');
   }
 }
\ No newline at end of file
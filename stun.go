@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+)
+
+// STUN message constants from RFC 5389
+const (
+	stunHeaderSize        = 20
+	stunMagicCookie       = 0x2112A442
+	stunBindingRequest    = 0x0001
+	stunBindingSuccess    = 0x0101
+	stunMappedAddress     = 0x0001
+	stunXORMappedAddress  = 0x0020
+	stunSoftware          = 0x8022
+	stunAddressFamilyIPv4 = 0x01
+	stunAddressFamilyIPv6 = 0x02
+)
+
+// Whether the embedded STUN server is bound, so clients can be sent to it. Guarded by mu.
+var stunListening bool
+
+// Parse a STUN binding request, returning its transaction ID. Anything else,
+// including other STUN methods, is ignored.
+func parseSTUNBinding(packet []byte) ([]byte, bool) {
+	if len(packet) < stunHeaderSize || packet[0]&0xC0 != 0 {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if binary.BigEndian.Uint16(packet[0:2]) != stunBindingRequest ||
+		binary.BigEndian.Uint32(packet[4:8]) != stunMagicCookie ||
+		length%4 != 0 || stunHeaderSize+length != len(packet) {
+		return nil, false
+	}
+	return packet[8:20], true
+}
+
+// Append an attribute, padded to a multiple of four bytes
+func appendSTUNAttribute(message []byte, kind uint16, value []byte) []byte {
+	message = binary.BigEndian.AppendUint16(message, kind)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(value)))
+	message = append(message, value...)
+	for len(message)%4 != 0 {
+		message = append(message, 0)
+	}
+	return message
+}
+
+// Build a binding success response telling the client the address its
+// request came from, both plain and XORed for clients behind NATs that
+// rewrite addresses in payloads
+func stunBindingResponse(transactionID []byte, addr *net.UDPAddr) []byte {
+	family := byte(stunAddressFamilyIPv6)
+	ip := addr.IP.To16()
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		family = stunAddressFamilyIPv4
+		ip = ip4
+	}
+
+	mapped := []byte{0, family}
+	mapped = binary.BigEndian.AppendUint16(mapped, uint16(addr.Port))
+	mapped = append(mapped, ip...)
+
+	// XOR the port and address with the magic cookie, and IPv6 addresses
+	// with the transaction ID as well
+	key := binary.BigEndian.AppendUint32(nil, stunMagicCookie)
+	key = append(key, transactionID...)
+	xored := []byte{0, family}
+	xored = binary.BigEndian.AppendUint16(xored, uint16(addr.Port)^uint16(stunMagicCookie>>16))
+	for i, b := range ip {
+		xored = append(xored, b^key[i])
+	}
+
+	message := binary.BigEndian.AppendUint16(nil, stunBindingSuccess)
+	message = binary.BigEndian.AppendUint16(message, 0) // Length, set below
+	message = binary.BigEndian.AppendUint32(message, stunMagicCookie)
+	message = append(message, transactionID...)
+	message = appendSTUNAttribute(message, stunXORMappedAddress, xored)
+	message = appendSTUNAttribute(message, stunMappedAddress, mapped)
+	message = appendSTUNAttribute(message, stunSoftware, []byte("Virtuaplex"))
+	binary.BigEndian.PutUint16(message[2:4], uint16(len(message)-stunHeaderSize))
+	return message
+}
+
+// Answer STUN binding requests on the configured UDP port so clients can
+// find their public address without a third-party STUN server
+func runSTUNServer() {
+	conn, err := net.ListenPacket("udp", ":"+config.STUNPort)
+	if err != nil {
+		log.Printf("Could not start STUN server: %v", err)
+		return
+	}
+	defer conn.Close()
+	log.Printf("STUN server listening on UDP port %s", config.STUNPort)
+
+	mu.Lock()
+	stunListening = true
+	mu.Unlock()
+
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("STUN read error: %v", err)
+			continue
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		transactionID, ok := parseSTUNBinding(buf[:n])
+		if !ok {
+			continue
+		}
+
+		if allowed, _ := allowRate("stun", udpAddr.IP.String()); !allowed {
+			continue
+		}
+
+		if _, err := conn.WriteTo(stunBindingResponse(transactionID, udpAddr), addr); err != nil {
+			log.Printf("STUN write error: %v", err)
+		}
+	}
+}
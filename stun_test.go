@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// Build a STUN header with the given type, body length and cookie, followed by body
+func stunPacket(kind uint16, cookie uint32, transactionID []byte, body []byte) []byte {
+	packet := binary.BigEndian.AppendUint16(nil, kind)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(len(body)))
+	packet = binary.BigEndian.AppendUint32(packet, cookie)
+	packet = append(packet, transactionID...)
+	return append(packet, body...)
+}
+
+func TestParseSTUNBinding(t *testing.T) {
+	transactionID := []byte("abcdefghijkl")
+	attribute := []byte{0x80, 0x22, 0x00, 0x04, 't', 'e', 's', 't'}
+
+	tests := []struct {
+		name   string
+		packet []byte
+		want   bool
+	}{
+		{"binding request", stunPacket(stunBindingRequest, stunMagicCookie, transactionID, nil), true},
+		{"with an attribute", stunPacket(stunBindingRequest, stunMagicCookie, transactionID, attribute), true},
+		{"too short", stunPacket(stunBindingRequest, stunMagicCookie, transactionID, nil)[:19], false},
+		{"binding response", stunPacket(stunBindingSuccess, stunMagicCookie, transactionID, nil), false},
+		{"other method", stunPacket(0x0003, stunMagicCookie, transactionID, nil), false},
+		{"no magic cookie", stunPacket(stunBindingRequest, 0x12345678, transactionID, nil), false},
+		{"not STUN", append([]byte{0xC0}, stunPacket(stunBindingRequest, stunMagicCookie, transactionID, nil)[1:]...), false},
+		{"length not a multiple of four", stunPacket(stunBindingRequest, stunMagicCookie, transactionID, attribute[:6]), false},
+		{"length past the packet", stunPacket(stunBindingRequest, stunMagicCookie, transactionID, attribute)[:24], false},
+		{"trailing bytes", append(stunPacket(stunBindingRequest, stunMagicCookie, transactionID, nil), 0, 0, 0, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseSTUNBinding(tt.packet)
+			if ok != tt.want {
+				t.Fatalf("parseSTUNBinding() ok = %v, want %v", ok, tt.want)
+			}
+			if ok && !bytes.Equal(got, transactionID) {
+				t.Errorf("transaction ID = %q, want %q", got, transactionID)
+			}
+		})
+	}
+}
+
+func TestSTUNBindingResponse(t *testing.T) {
+	transactionID := []byte("abcdefghijkl")
+
+	tests := []struct {
+		name   string
+		addr   *net.UDPAddr
+		family byte
+	}{
+		{"IPv4", &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 54321}, stunAddressFamilyIPv4},
+		{"IPv4 in IPv6 form", &net.UDPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 3478}, stunAddressFamilyIPv4},
+		{"IPv6", &net.UDPAddr{IP: net.ParseIP("2001:db8::1234:5678"), Port: 65535}, stunAddressFamilyIPv6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := stunBindingResponse(transactionID, tt.addr)
+
+			if binary.BigEndian.Uint16(message[0:2]) != stunBindingSuccess {
+				t.Fatalf("message type %#04x", binary.BigEndian.Uint16(message[0:2]))
+			}
+			if int(binary.BigEndian.Uint16(message[2:4])) != len(message)-stunHeaderSize || len(message)%4 != 0 {
+				t.Fatalf("length %d for a %d byte message", binary.BigEndian.Uint16(message[2:4]), len(message))
+			}
+			if binary.BigEndian.Uint32(message[4:8]) != stunMagicCookie || !bytes.Equal(message[8:20], transactionID) {
+				t.Fatal("cookie or transaction ID not echoed")
+			}
+
+			// Walk the attributes, decoding both address forms per RFC 5389
+			attributes := make(map[uint16][]byte)
+			for rest := message[stunHeaderSize:]; len(rest) >= 4; {
+				kind := binary.BigEndian.Uint16(rest[0:2])
+				length := int(binary.BigEndian.Uint16(rest[2:4]))
+				attributes[kind] = rest[4 : 4+length]
+				rest = rest[4+(length+3)/4*4:]
+			}
+
+			mapped := attributes[stunMappedAddress]
+			xored := attributes[stunXORMappedAddress]
+			if mapped == nil || xored == nil {
+				t.Fatalf("missing address attributes: %v", attributes)
+			}
+			for _, value := range [][]byte{mapped, xored} {
+				if value[1] != tt.family {
+					t.Errorf("family %d, want %d", value[1], tt.family)
+				}
+			}
+
+			if port := int(binary.BigEndian.Uint16(mapped[2:4])); port != tt.addr.Port {
+				t.Errorf("MAPPED-ADDRESS port %d, want %d", port, tt.addr.Port)
+			}
+			if ip := net.IP(mapped[4:]); !ip.Equal(tt.addr.IP) {
+				t.Errorf("MAPPED-ADDRESS %v, want %v", ip, tt.addr.IP)
+			}
+
+			key := append(binary.BigEndian.AppendUint32(nil, stunMagicCookie), transactionID...)
+			port := int(binary.BigEndian.Uint16(xored[2:4]) ^ uint16(stunMagicCookie>>16))
+			ip := make(net.IP, len(xored)-4)
+			for i := range ip {
+				ip[i] = xored[4+i] ^ key[i]
+			}
+			if port != tt.addr.Port {
+				t.Errorf("XOR-MAPPED-ADDRESS port %d, want %d", port, tt.addr.Port)
+			}
+			if !ip.Equal(tt.addr.IP) {
+				t.Errorf("XOR-MAPPED-ADDRESS %v, want %v", ip, tt.addr.IP)
+			}
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Subtitle formats operators can upload
+const (
+	SubtitleSRT = "srt"
+	SubtitleASS = "ass"
+	SubtitleVTT = "vtt"
+)
+
+// Largest subtitle file accepted, well above any feature film's
+const maxSubtitleSize = 2 << 20
+
+// Subtitle is one language's subtitle track for a film, kept as parsed cues
+// so the timing offset can be changed without uploading it again
+type Subtitle struct {
+	Language   string    `json:"language"`
+	Label      string    `json:"label"`
+	Format     string    `json:"format"`    // Format it was uploaded in
+	OffsetMS   int64     `json:"offset_ms"` // Added to every cue; negative shows them earlier
+	Cues       int       `json:"cues"`
+	UploadedBy string    `json:"uploaded_by"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	cues       []subtitleCue
+}
+
+// SubtitleTrack points a screening's visitors at a WebVTT track
+type SubtitleTrack struct {
+	Language string `json:"language"`
+	Label    string `json:"label"`
+	URL      string `json:"url"`
+}
+
+type subtitleCue struct {
+	Start    time.Duration
+	End      time.Duration
+	Settings string // WebVTT cue settings, e.g. "line:0"
+	Text     string
+}
+
+var (
+	subtitles = make(map[string]map[string]*Subtitle) // Film ID -> language -> track
+
+	languageTag  = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	cueTiming    = regexp.MustCompile(`^\s*([0-9:.,]+)\s*-->\s*([0-9:.,]+)\s*(.*)$`)
+	assOverrides = regexp.MustCompile(`\{[^}]*\}`)
+	srtFontTags  = regexp.MustCompile(`(?i)</?font[^>]*>`)
+	srtStyleTags = regexp.MustCompile(`(?i)&lt;/?[biu]&gt;`) // Escaped <b>, <i> and <u>, to put back
+)
+
+// Parse a timestamp like 01:02:03,456, 01:02:03.456, 02:03.456 or 1:02:03.45
+func parseSubtitleTime(value string) (time.Duration, error) {
+	value = strings.Replace(value, ",", ".", 1)
+	clock, fraction, _ := strings.Cut(value, ".")
+
+	parts := strings.Split(clock, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+	var total time.Duration
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		total = total*60 + time.Duration(n)
+	}
+	total *= time.Second
+
+	if fraction != "" {
+		n, err := strconv.Atoi(fraction)
+		if err != nil || n < 0 || len(fraction) > 9 {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		for i := len(fraction); i < 9; i++ {
+			n *= 10
+		}
+		total += time.Duration(n)
+	}
+	return total, nil
+}
+
+// Format a timestamp the way WebVTT writes it
+func formatVTTTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// Split text into blank-line separated blocks of lines, dropping a byte order mark
+func subtitleBlocks(content string) [][]string {
+	content = strings.TrimPrefix(content, "\ufeff")
+	var blocks [][]string
+	var block []string
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), maxSubtitleSize)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+				block = nil
+			}
+			continue
+		}
+		block = append(block, line)
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// Parse the cue of a block starting with an optional identifier and a
+// timing line. Returns false for blocks that aren't cues.
+func parseTimedBlock(block []string) (subtitleCue, bool, error) {
+	timing := 0
+	if !strings.Contains(block[0], "-->") {
+		timing = 1
+	}
+	if timing >= len(block) || !strings.Contains(block[timing], "-->") {
+		return subtitleCue{}, false, nil
+	}
+
+	match := cueTiming.FindStringSubmatch(block[timing])
+	if match == nil {
+		return subtitleCue{}, false, fmt.Errorf("invalid timing line %q", block[timing])
+	}
+	start, err := parseSubtitleTime(match[1])
+	if err != nil {
+		return subtitleCue{}, false, err
+	}
+	end, err := parseSubtitleTime(match[2])
+	if err != nil {
+		return subtitleCue{}, false, err
+	}
+	return subtitleCue{Start: start, End: end, Settings: match[3], Text: strings.Join(block[timing+1:], "\n")}, true, nil
+}
+
+// Parse SubRip: numbered cues with comma-separated milliseconds and
+// HTML-like styling, of which WebVTT knows <b>, <i> and <u>
+func parseSRT(content string) ([]subtitleCue, error) {
+	var cues []subtitleCue
+	for _, block := range subtitleBlocks(content) {
+		cue, ok, err := parseTimedBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		// SubRip position coordinates have no WebVTT equivalent
+		cue.Settings = ""
+		cue.Text = srtFontTags.ReplaceAllString(cue.Text, "")
+		cue.Text = assOverrides.ReplaceAllString(cue.Text, "")
+
+		// Anything else that looks like markup is shown as text
+		cue.Text = srtStyleTags.ReplaceAllStringFunc(escapeVTTText(cue.Text), func(tag string) string {
+			return "<" + strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(tag, "&lt;"), "&gt;")) + ">"
+		})
+		cues = append(cues, cue)
+	}
+	return cues, nil
+}
+
+// Parse WebVTT, skipping the header and NOTE, STYLE and REGION blocks
+func parseVTT(content string) ([]subtitleCue, error) {
+	blocks := subtitleBlocks(content)
+	if len(blocks) == 0 || !strings.HasPrefix(blocks[0][0], "WEBVTT") {
+		return nil, errors.New("missing WEBVTT header")
+	}
+
+	var cues []subtitleCue
+	for _, block := range blocks[1:] {
+		if strings.HasPrefix(block[0], "NOTE") || block[0] == "STYLE" || block[0] == "REGION" {
+			continue
+		}
+		cue, ok, err := parseTimedBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			cues = append(cues, cue)
+		}
+	}
+	return cues, nil
+}
+
+// Parse the Dialogue lines of an SSA/ASS script's [Events] section, using
+// its Format line to find the start, end and text fields. Styling is dropped.
+func parseASS(content string) ([]subtitleCue, error) {
+	var cues []subtitleCue
+	var fields []string
+	inEvents := false
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimPrefix(content, "\ufeff")))
+	scanner.Buffer(make([]byte, 64*1024), maxSubtitleSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		switch key {
+		case "Format":
+			fields = nil
+			for _, field := range strings.Split(value, ",") {
+				fields = append(fields, strings.ToLower(strings.TrimSpace(field)))
+			}
+		case "Dialogue":
+			if fields == nil {
+				return nil, errors.New("Dialogue before Format in [Events]")
+			}
+			values := strings.SplitN(value, ",", len(fields))
+			if len(values) != len(fields) {
+				return nil, fmt.Errorf("malformed Dialogue line %q", line)
+			}
+
+			var cue subtitleCue
+			var err error
+			for i, field := range fields {
+				switch field {
+				case "start":
+					cue.Start, err = parseSubtitleTime(strings.TrimSpace(values[i]))
+				case "end":
+					cue.End, err = parseSubtitleTime(strings.TrimSpace(values[i]))
+				case "text":
+					text := assOverrides.ReplaceAllString(values[i], "")
+					text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+					cue.Text = escapeVTTText(strings.TrimSpace(text))
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			if cue.Text != "" {
+				cues = append(cues, cue)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, errors.New("no [Events] section")
+	}
+
+	// Scripts list events by layer, not time
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Start < cues[j].Start })
+	return cues, nil
+}
+
+// Escape the characters WebVTT cue text reserves, for formats without markup
+func escapeVTTText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// Work out a file's format from its contents
+func detectSubtitleFormat(content string) string {
+	trimmed := strings.TrimSpace(strings.TrimPrefix(content, "\ufeff"))
+	switch {
+	case strings.HasPrefix(trimmed, "WEBVTT"):
+		return SubtitleVTT
+	case strings.HasPrefix(trimmed, "[Script Info]") || strings.Contains(content, "[Events]"):
+		return SubtitleASS
+	default:
+		return SubtitleSRT
+	}
+}
+
+// Render a track as WebVTT with its offset applied. Cues shifted entirely
+// before the start are dropped.
+func (s *Subtitle) webVTT() string {
+	offset := time.Duration(s.OffsetMS) * time.Millisecond
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, cue := range s.cues {
+		start, end := cue.Start+offset, cue.End+offset
+		if end <= 0 || end <= start {
+			continue
+		}
+		if start < 0 {
+			start = 0
+		}
+		b.WriteString("\n" + formatVTTTime(start) + " --> " + formatVTTTime(end))
+		if cue.Settings != "" {
+			b.WriteString(" " + cue.Settings)
+		}
+		b.WriteString("\n")
+
+		// A blank line would end the cue early
+		for _, line := range strings.Split(cue.Text, "\n") {
+			if strings.TrimSpace(line) != "" {
+				b.WriteString(strings.ReplaceAll(line, "-->", "--&gt;") + "\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// Subtitle tracks of a film for a screening response, by language. Must be called with mu held.
+func subtitleTracks(c *gin.Context, filmID string) []SubtitleTrack {
+	tracks := []SubtitleTrack{}
+	for _, subtitle := range subtitles[filmID] {
+		tracks = append(tracks, SubtitleTrack{
+			Language: subtitle.Language,
+			Label:    subtitle.Label,
+			URL:      publicURL(c) + "/api/films/" + filmID + "/subtitles/" + subtitle.Language + ".vtt",
+		})
+	}
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].Language < tracks[j].Language })
+	return tracks
+}
+
+// List a film's subtitle tracks
+func listSubtitles(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := films[c.Param("id")]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Film not found"})
+		return
+	}
+
+	result := []*Subtitle{}
+	for _, subtitle := range subtitles[c.Param("id")] {
+		result = append(result, subtitle)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Language < result[j].Language })
+
+	c.JSON(http.StatusOK, gin.H{"subtitles": result})
+}
+
+// Serve a track as WebVTT, e.g. /api/films/{id}/subtitles/en.vtt
+func serveSubtitle(c *gin.Context) {
+	language, isVTT := strings.CutSuffix(c.Param("file"), ".vtt")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	subtitle, exists := subtitles[c.Param("id")][language]
+	if !isVTT || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subtitles not found"})
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Data(http.StatusOK, "text/vtt; charset=utf-8", []byte(subtitle.webVTT()))
+}
+
+// Upload or replace a film's subtitles in one language, converting them to
+// WebVTT. Sending only a label or offset updates the existing track.
+func uploadSubtitle(c *gin.Context) {
+	var request struct {
+		Content  string `json:"content"`
+		Format   string `json:"format"`
+		Label    string `json:"label"`
+		OffsetMS *int64 `json:"offset_ms"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	language := c.Param("lang")
+	if !languageTag.MatchString(language) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Language must be a tag like en or pt-BR"})
+		return
+	}
+	if len(request.Content) > maxSubtitleSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Subtitle file too large"})
+		return
+	}
+
+	var cues []subtitleCue
+	if request.Content != "" {
+		format := strings.ToLower(request.Format)
+		if format == "" {
+			format = detectSubtitleFormat(request.Content)
+		}
+
+		var err error
+		switch format {
+		case SubtitleSRT:
+			cues, err = parseSRT(request.Content)
+		case SubtitleASS, "ssa":
+			format = SubtitleASS
+			cues, err = parseASS(request.Content)
+		case SubtitleVTT:
+			cues, err = parseVTT(request.Content)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be srt, ass or vtt"})
+			return
+		}
+		if err == nil && len(cues) == 0 {
+			err = errors.New("no cues found")
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + format + " subtitles: " + err.Error()})
+			return
+		}
+		request.Format = format
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	filmID := c.Param("id")
+	if _, exists := films[filmID]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Film not found"})
+		return
+	}
+
+	subtitle, exists := subtitles[filmID][language]
+	if !exists {
+		if cues == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Subtitle content is required"})
+			return
+		}
+		subtitle = &Subtitle{Language: language, Label: language}
+		if subtitles[filmID] == nil {
+			subtitles[filmID] = make(map[string]*Subtitle)
+		}
+		subtitles[filmID][language] = subtitle
+	}
+
+	if cues != nil {
+		subtitle.cues = cues
+		subtitle.Cues = len(cues)
+		subtitle.Format = request.Format
+		subtitle.UploadedBy = currentOperator(c).Name
+		subtitle.UploadedAt = time.Now()
+	}
+	if request.Label != "" {
+		subtitle.Label = request.Label
+	}
+	if request.OffsetMS != nil {
+		subtitle.OffsetMS = *request.OffsetMS
+	}
+
+	c.JSON(http.StatusOK, subtitle)
+}
+
+// Remove a film's subtitles in one language
+func deleteSubtitle(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := subtitles[c.Param("id")][c.Param("lang")]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subtitles not found"})
+		return
+	}
+	delete(subtitles[c.Param("id")], c.Param("lang"))
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSubtitleTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "01:02:03,456", want: time.Hour + 2*time.Minute + 3*time.Second + 456*time.Millisecond},
+		{value: "01:02:03.456", want: time.Hour + 2*time.Minute + 3*time.Second + 456*time.Millisecond},
+		{value: "02:03.456", want: 2*time.Minute + 3*time.Second + 456*time.Millisecond},
+		{value: "1:02:03.45", want: time.Hour + 2*time.Minute + 3*time.Second + 450*time.Millisecond},
+		{value: "0:00:00.5", want: 500 * time.Millisecond},
+		{value: "00:00:01", want: time.Second},
+		{value: "00:00:00.123456789", want: 123456789},
+		{value: "12", wantErr: true},
+		{value: "1:2:3:4", wantErr: true},
+		{value: "aa:00:00.000", wantErr: true},
+		{value: "00:-1:00.000", wantErr: true},
+		{value: "00:00:00.x", wantErr: true},
+		{value: "00:00:00.1234567890", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSubtitleTime(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSubtitleTime(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseSubtitleTime(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseSRT(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []subtitleCue
+		wantErr bool
+	}{
+		{
+			name:    "numbered cues",
+			content: "1\n00:00:01,000 --> 00:00:02,500\nHello\n\n2\n00:00:03,000 --> 00:00:04,000\nTwo\nlines\n",
+			want: []subtitleCue{
+				{Start: time.Second, End: 2500 * time.Millisecond, Text: "Hello"},
+				{Start: 3 * time.Second, End: 4 * time.Second, Text: "Two\nlines"},
+			},
+		},
+		{
+			name:    "byte order mark and CRLF",
+			content: "\ufeff1\r\n00:00:01,000 --> 00:00:02,000\r\nHello\r\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2 * time.Second, Text: "Hello"}},
+		},
+		{
+			name:    "arrow without spaces",
+			content: "1\n00:00:01,000-->00:00:02,000\nHello\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2 * time.Second, Text: "Hello"}},
+		},
+		{
+			name:    "position coordinates dropped",
+			content: "1\n00:00:01,000 --> 00:00:02,000 X1:100 X2:200 Y1:10 Y2:20\nHello\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2 * time.Second, Text: "Hello"}},
+		},
+		{
+			name:    "b, i and u kept",
+			content: "1\n00:00:01,000 --> 00:00:02,000\n<i>Hello</i> <B>there</B> <u>you</u>\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2 * time.Second, Text: "<i>Hello</i> <b>there</b> <u>you</u>"}},
+		},
+		{
+			name:    "font tags and ASS overrides dropped",
+			content: "1\n00:00:01,000 --> 00:00:02,000\n<font color=\"red\">{\\an8}Hello</font>\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2 * time.Second, Text: "Hello"}},
+		},
+		{
+			name:    "other markup escaped",
+			content: "1\n00:00:01,000 --> 00:00:02,000\n<c.yellow>Tom & Jerry</c> <script>x</script> 1 < 2\n",
+			want: []subtitleCue{{Start: time.Second, End: 2 * time.Second,
+				Text: "&lt;c.yellow&gt;Tom &amp; Jerry&lt;/c&gt; &lt;script&gt;x&lt;/script&gt; 1 &lt; 2"}},
+		},
+		{
+			name:    "blocks without timing skipped",
+			content: "Just a note\n\n1\n00:00:01,000 --> 00:00:02,000\nHello\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2 * time.Second, Text: "Hello"}},
+		},
+		{name: "bad timestamp", content: "1\n00:00:xx,000 --> 00:00:02,000\nHello\n", wantErr: true},
+		{name: "missing end", content: "1\n00:00:01,000 -->\nHello\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSRT(tt.content)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSRT() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSRT() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSRT() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseASS(t *testing.T) {
+	const header = "[Script Info]\nTitle: Test\n\n[V4+ Styles]\nFormat: Name, Fontname\nStyle: Default,Arial\n\n[Events]\n" +
+		"Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n"
+
+	tests := []struct {
+		name    string
+		content string
+		want    []subtitleCue
+		wantErr bool
+	}{
+		{
+			name:    "dialogue with commas in the text",
+			content: header + "Dialogue: 0,0:00:01.00,0:00:02.50,Default,,0,0,0,,Hello, world\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2500 * time.Millisecond, Text: "Hello, world"}},
+		},
+		{
+			name:    "overrides dropped, line breaks and hard spaces converted",
+			content: header + "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,{\\i1}One\\NTwo\\hthree{\\i0}\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2 * time.Second, Text: "One\nTwo three"}},
+		},
+		{
+			name:    "markup characters escaped",
+			content: header + "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,<b>A & B</b>\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2 * time.Second, Text: "&lt;b&gt;A &amp; B&lt;/b&gt;"}},
+		},
+		{
+			name: "sorted by start, empty text dropped",
+			content: header +
+				"Dialogue: 1,0:00:05.00,0:00:06.00,Default,,0,0,0,,Later\n" +
+				"Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,{\\p1}\n" +
+				"Dialogue: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,Sooner\n" +
+				"Comment: 0,0:00:00.00,0:00:09.00,Default,,0,0,0,,Not shown\n",
+			want: []subtitleCue{
+				{Start: 3 * time.Second, End: 4 * time.Second, Text: "Sooner"},
+				{Start: 5 * time.Second, End: 6 * time.Second, Text: "Later"},
+			},
+		},
+		{
+			name:    "custom field order",
+			content: "[Events]\nFormat: Text, End, Start\nDialogue: Hi,0:00:02.00,0:00:01.00\n",
+			want:    []subtitleCue{{Start: time.Second, End: 2 * time.Second, Text: "Hi"}},
+		},
+		{name: "no events", content: "[Script Info]\nTitle: Test\n", wantErr: true},
+		{name: "dialogue before format", content: "[Events]\nDialogue: 0,0:00:01.00,0:00:02.00,Hi\n", wantErr: true},
+		{name: "too few fields", content: header + "Dialogue: 0,0:00:01.00\n", wantErr: true},
+		{name: "bad time", content: header + "Dialogue: 0,0:0x:01.00,0:00:02.00,Default,,0,0,0,,Hi\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseASS(tt.content)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseASS() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseASS() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseASS() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebVTT(t *testing.T) {
+	cues := []subtitleCue{
+		{Start: 500 * time.Millisecond, End: 1500 * time.Millisecond, Text: "First"},
+		{Start: 2 * time.Second, End: 3 * time.Second, Settings: "line:0", Text: "Second\n\nafter a gap"},
+		{Start: 4 * time.Second, End: 5 * time.Second, Text: "a --> b"},
+	}
+
+	tests := []struct {
+		name     string
+		offsetMS int64
+		want     string
+	}{
+		{
+			name: "no offset",
+			want: "WEBVTT\n" +
+				"\n00:00:00.500 --> 00:00:01.500\nFirst\n" +
+				"\n00:00:02.000 --> 00:00:03.000 line:0\nSecond\nafter a gap\n" +
+				"\n00:00:04.000 --> 00:00:05.000\na --&gt; b\n",
+		},
+		{
+			name:     "later",
+			offsetMS: 3601000,
+			want: "WEBVTT\n" +
+				"\n01:00:01.500 --> 01:00:02.500\nFirst\n" +
+				"\n01:00:03.000 --> 01:00:04.000 line:0\nSecond\nafter a gap\n" +
+				"\n01:00:05.000 --> 01:00:06.000\na --&gt; b\n",
+		},
+		{
+			name:     "earlier, clamping and dropping cues before the start",
+			offsetMS: -2500,
+			want: "WEBVTT\n" +
+				"\n00:00:00.000 --> 00:00:00.500 line:0\nSecond\nafter a gap\n" +
+				"\n00:00:01.500 --> 00:00:02.500\na --&gt; b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subtitle := &Subtitle{OffsetMS: tt.offsetMS, cues: cues}
+			if got := subtitle.webVTT(); got != tt.want {
+				t.Errorf("webVTT() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
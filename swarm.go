@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Swarm health statuses
+const (
+	SwarmNoData    = "no_data"
+	SwarmHealthy   = "healthy"
+	SwarmDegraded  = "degraded"
+	SwarmBuffering = "buffering"
+)
+
+// SwarmReport is a visitor's latest view of the screening's torrent
+type SwarmReport struct {
+	Peers        int       `json:"peers"`
+	DownloadRate float64   `json:"download_rate"` // Bytes per second
+	UploadRate   float64   `json:"upload_rate"`   // Bytes per second
+	Buffered     float64   `json:"buffered"`      // Percent of the feature file downloaded
+	Stalls       int       `json:"stalls"`        // Playback stalls since the previous report
+	TotalStalls  int       `json:"total_stalls"`
+	ReceivedAt   time.Time `json:"received_at"`
+}
+
+// SwarmHealth aggregates the fresh reports of one lobby
+type SwarmHealth struct {
+	ScreeningID  string    `json:"screening_id"`
+	ScheduleID   string    `json:"schedule_id"`
+	Title        string    `json:"title"`
+	Status       string    `json:"status"`
+	Viewers      int       `json:"viewers"`
+	Reporting    int       `json:"reporting"`
+	WithoutPeers int       `json:"without_peers"` // Reporters connected to nobody
+	Stalling     int       `json:"stalling"`      // Reporters that stalled since their previous report
+	AveragePeers float64   `json:"average_peers"`
+	DownloadRate float64   `json:"download_rate"` // Sum over reporters, bytes per second
+	UploadRate   float64   `json:"upload_rate"`
+	Buffered     float64   `json:"buffered"` // Average percent downloaded
+	Stalls       int       `json:"stalls"`   // Stalls in the latest reports
+	TotalStalls  int       `json:"total_stalls"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+var swarmReports = make(map[string]*SwarmReport) // Visitor ID -> latest report
+
+// Record a visitor's swarm_stats message. Reports arriving faster than
+// half the report interval are dropped. Must be called with mu held.
+func handleSwarmStats(conn *websocket.Conn, visitor *Visitor, data interface{}) {
+	var report SwarmReport
+	if err := decodeMessageData(data, &report); err != nil || report.Peers < 0 || report.DownloadRate < 0 ||
+		report.UploadRate < 0 || report.Stalls < 0 {
+		sendError(conn, "Invalid swarm stats")
+		return
+	}
+
+	now := time.Now()
+	previous, exists := swarmReports[visitor.ID]
+	if exists && now.Sub(previous.ReceivedAt) < config.SwarmReportInterval/2 {
+		return
+	}
+
+	if report.Buffered < 0 {
+		report.Buffered = 0
+	} else if report.Buffered > 100 {
+		report.Buffered = 100
+	}
+	report.TotalStalls = report.Stalls
+	if exists {
+		report.TotalStalls += previous.TotalStalls
+	}
+	report.ReceivedAt = now
+	swarmReports[visitor.ID] = &report
+}
+
+// Aggregate a lobby's reports, ignoring ones older than a few report
+// intervals. Must be called with mu held.
+func swarmHealth(screening *Screening, now time.Time) *SwarmHealth {
+	health := &SwarmHealth{
+		ScreeningID: screening.ID,
+		ScheduleID:  screening.ScheduleID,
+		Title:       screening.Title,
+		Status:      SwarmNoData,
+		UpdatedAt:   now,
+	}
+
+	var peers, buffered float64
+	for _, visitor := range visitors {
+		if visitor.ScreeningID != screening.ID || visitor.queuedFor != "" {
+			continue
+		}
+		health.Viewers++
+
+		report, exists := swarmReports[visitor.ID]
+		if !exists || now.Sub(report.ReceivedAt) > 3*config.SwarmReportInterval {
+			continue
+		}
+		health.Reporting++
+		peers += float64(report.Peers)
+		buffered += report.Buffered
+		health.DownloadRate += report.DownloadRate
+		health.UploadRate += report.UploadRate
+		health.Stalls += report.Stalls
+		health.TotalStalls += report.TotalStalls
+		if report.Peers == 0 {
+			health.WithoutPeers++
+		}
+		if report.Stalls > 0 {
+			health.Stalling++
+		}
+	}
+
+	if health.Reporting == 0 {
+		return health
+	}
+	health.AveragePeers = peers / float64(health.Reporting)
+	health.Buffered = buffered / float64(health.Reporting)
+
+	// A quarter of the room stalling is noticed in chat soon after
+	switch {
+	case health.Stalling*4 >= health.Reporting:
+		health.Status = SwarmBuffering
+	case health.Stalling > 0 || health.WithoutPeers*2 > health.Reporting:
+		health.Status = SwarmDegraded
+	default:
+		health.Status = SwarmHealthy
+	}
+	return health
+}
+
+// Health of every lobby that hasn't ended, worst first. Must be called with mu held.
+func swarmSnapshot(now time.Time) []*SwarmHealth {
+	rank := map[string]int{SwarmBuffering: 0, SwarmDegraded: 1, SwarmHealthy: 2, SwarmNoData: 3}
+
+	result := []*SwarmHealth{}
+	for _, screening := range screenings {
+		if now.After(screening.EndTime) {
+			continue
+		}
+		result = append(result, swarmHealth(screening, now))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if rank[result[i].Status] != rank[result[j].Status] {
+			return rank[result[i].Status] < rank[result[j].Status]
+		}
+		return result[i].ScreeningID < result[j].ScreeningID
+	})
+	return result
+}
+
+// Get the swarm health of every active lobby
+func getSwarmHealth(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"screenings": swarmSnapshot(time.Now())})
+}
+
+// Get one lobby's swarm health
+func getScreeningSwarmHealth(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	screening, exists := screenings[c.Param("id")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Screening not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, swarmHealth(screening, time.Now()))
+}
+
+// Stream the swarm health of every active lobby as server-sent events,
+// once per report interval
+func streamSwarmHealth(c *gin.Context) {
+	ticker := time.NewTicker(config.SwarmReportInterval)
+	defer ticker.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	// A stalled reader fails its write instead of holding the stream open
+	controller := http.NewResponseController(c.Writer)
+	send := func() bool {
+		mu.Lock()
+		snapshot := swarmSnapshot(time.Now())
+		mu.Unlock()
+		controller.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		c.SSEvent("swarm", gin.H{"screenings": snapshot})
+		return controller.Flush() == nil
+	}
+	if !send() {
+		return
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ticker.C:
+			return send()
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
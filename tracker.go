@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// TrackerMessage is a WebTorrent WebSocket tracker request or response.
+// Info hashes and peer IDs are 20-byte binary strings, as in the protocol.
+type TrackerMessage struct {
+	Action     string           `json:"action"`
+	InfoHash   json.RawMessage  `json:"info_hash,omitempty"` // A string, or an array of them when scraping
+	PeerID     string           `json:"peer_id,omitempty"`
+	NumWant    int              `json:"numwant,omitempty"`
+	Left       *float64         `json:"left,omitempty"`
+	Downloaded *float64         `json:"downloaded,omitempty"`
+	Event      string           `json:"event,omitempty"`
+	Offers     []TrackerOffer   `json:"offers,omitempty"`
+	Answer     *json.RawMessage `json:"answer,omitempty"`
+	OfferID    string           `json:"offer_id,omitempty"`
+	ToPeerID   string           `json:"to_peer_id,omitempty"`
+	Complete   *int             `json:"complete,omitempty"`
+	Incomplete *int             `json:"incomplete,omitempty"`
+	Interval   int              `json:"interval,omitempty"`
+}
+
+// TrackerOffer is a WebRTC offer a peer hands the tracker to pass on
+type TrackerOffer struct {
+	Offer   json.RawMessage `json:"offer"`
+	OfferID string          `json:"offer_id"`
+}
+
+// peerPlacement is where a peer's visitor sat at its latest announce, for matching
+type peerPlacement struct {
+	screeningID string // Lobby from the signed tracker URL; empty for outside peers
+	scheduleID  string
+	seat        *SeatPosition
+}
+
+// trackerPeer is one peer in a swarm
+type trackerPeer struct {
+	conn      *websocket.Conn
+	place     peerPlacement
+	complete  bool            // Has the whole torrent
+	hasData   bool            // Has downloaded something, so holds the early pieces
+	connected map[string]bool // Peer IDs it has been matched with
+}
+
+var (
+	// Guards swarms. Anyone can announce, so the tracker keeps off mu; it may
+	// be taken while holding mu, never the other way round.
+	trackerMu sync.Mutex
+	swarms    = make(map[string]map[string]*trackerPeer) // Info hash -> peer ID -> peer
+)
+
+// Decode the info hashes of an announce or scrape
+func (m TrackerMessage) infoHashes() []string {
+	var single string
+	if err := json.Unmarshal(m.InfoHash, &single); err == nil {
+		return []string{single}
+	}
+	var many []string
+	json.Unmarshal(m.InfoHash, &many)
+	return many
+}
+
+// Count seeders and leechers in a swarm. Must be called with trackerMu held.
+func swarmCounts(infoHash string) (int, int) {
+	complete, incomplete := 0, 0
+	for _, peer := range swarms[infoHash] {
+		if peer.complete {
+			complete++
+		} else {
+			incomplete++
+		}
+	}
+	return complete, incomplete
+}
+
+// Send a message to a tracker peer. Must be called with trackerMu held.
+func sendToTrackerPeer(conn *websocket.Conn, message interface{}) {
+	sendJSON(conn, message)
+}
+
+// Handle an announce: track the peer, relay an answer to the peer that made
+// the offer, or hand the peer's offers to the best matched peers in the
+// swarm. Must be called with trackerMu held.
+func trackerAnnounce(conn *websocket.Conn, place peerPlacement, message TrackerMessage, infoHash string) {
+	if utf8.RuneCountInString(infoHash) != 20 || utf8.RuneCountInString(message.PeerID) != 20 {
+		sendToTrackerPeer(conn, gin.H{"action": "announce", "failure reason": "invalid info_hash or peer_id"})
+		return
+	}
+
+	// A peer ID belongs to the connection that announced it first
+	peer, exists := swarms[infoHash][message.PeerID]
+	if exists && peer.conn != conn {
+		sendToTrackerPeer(conn, gin.H{"action": "announce", "failure reason": "peer_id is in use"})
+		return
+	}
+
+	if message.Event == "stopped" {
+		if exists {
+			removeTrackerPeer(infoHash, message.PeerID)
+		}
+		return
+	}
+
+	swarm, swarmExists := swarms[infoHash]
+	if !swarmExists {
+		swarm = make(map[string]*trackerPeer)
+		swarms[infoHash] = swarm
+	}
+	if !exists {
+		peer = &trackerPeer{
+			conn:      conn,
+			connected: make(map[string]bool),
+		}
+		swarm[message.PeerID] = peer
+	}
+	peer.place = place
+	if message.Event == "completed" || (message.Left != nil && *message.Left == 0) {
+		peer.complete = true
+	}
+	if peer.complete || (message.Downloaded != nil && *message.Downloaded > 0) {
+		peer.hasData = true
+	}
+
+	// Answers go straight back to whoever made the offer
+	if message.Answer != nil {
+		if target, exists := swarm[message.ToPeerID]; exists {
+			peer.connected[message.ToPeerID] = true
+			target.connected[message.PeerID] = true
+			sendToTrackerPeer(target.conn, TrackerMessage{
+				Action:   "announce",
+				Answer:   message.Answer,
+				OfferID:  message.OfferID,
+				PeerID:   message.PeerID,
+				InfoHash: message.InfoHash,
+			})
+		}
+		return
+	}
+
+	complete, incomplete := swarmCounts(infoHash)
+	sendToTrackerPeer(conn, TrackerMessage{
+		Action:     "announce",
+		InfoHash:   message.InfoHash,
+		Interval:   int(config.TrackerInterval.Seconds()),
+		Complete:   &complete,
+		Incomplete: &incomplete,
+	})
+
+	// Pass each offer to a different peer, best matches first
+	want := len(message.Offers)
+	if message.NumWant > 0 && message.NumWant < want {
+		want = message.NumWant
+	}
+	for i, target := range choosePeers(swarm, message.PeerID, want) {
+		offer := message.Offers[i]
+		sendToTrackerPeer(target.conn, gin.H{
+			"action":    "announce",
+			"offer":     offer.Offer,
+			"offer_id":  offer.OfferID,
+			"peer_id":   message.PeerID,
+			"info_hash": message.InfoHash,
+		})
+	}
+}
+
+// Handle a scrape of some or all swarms. Must be called with trackerMu held.
+func trackerScrape(conn *websocket.Conn, message TrackerMessage) {
+	infoHashes := message.infoHashes()
+	if len(infoHashes) == 0 {
+		for infoHash := range swarms {
+			infoHashes = append(infoHashes, infoHash)
+		}
+	}
+
+	files := gin.H{}
+	for _, infoHash := range infoHashes {
+		complete, incomplete := swarmCounts(infoHash)
+		files[infoHash] = gin.H{
+			"complete":   complete,
+			"incomplete": incomplete,
+			"downloaded": complete,
+		}
+	}
+
+	sendToTrackerPeer(conn, gin.H{"action": "scrape", "files": files})
+}
+
+// Drop a peer from a swarm and from its partners' connection counts. Must be called with trackerMu held.
+func removeTrackerPeer(infoHash, peerID string) {
+	swarm := swarms[infoHash]
+	delete(swarm, peerID)
+	for _, other := range swarm {
+		delete(other.connected, peerID)
+	}
+	if len(swarm) == 0 {
+		delete(swarms, infoHash)
+	}
+}
+
+// Drop every peer announced over a closed connection
+func removeTrackerConn(conn *websocket.Conn) {
+	trackerMu.Lock()
+	defer trackerMu.Unlock()
+
+	for infoHash, swarm := range swarms {
+		for peerID, peer := range swarm {
+			if peer.conn == conn {
+				removeTrackerPeer(infoHash, peerID)
+			}
+		}
+	}
+}
+
+// Serve the WebTorrent WebSocket tracker protocol
+func handleTracker(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade tracker connection: %v", err)
+		return
+	}
+	defer conn.Close()
+	startWriter(conn)
+	defer stopWriter(conn)
+	defer removeTrackerConn(conn)
+
+	// Tracker URLs handed out with screenings say who is announcing. Without
+	// a valid signature the peer is matched like any outside peer.
+	screeningID := c.Query("screening")
+	visitorID := c.Query("visitor")
+	if !hmac.Equal([]byte(c.Query("sig")), []byte(trackerSignature(screeningID, visitorID))) {
+		screeningID, visitorID = "", ""
+	}
+
+	// Peers re-announce every interval; anything silent for twice that is gone
+	conn.SetReadLimit(64 * 1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * config.TrackerInterval))
+
+		var message TrackerMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+
+		if allowed, _ := allowRate("tracker", c.ClientIP()); !allowed {
+			continue
+		}
+
+		var place peerPlacement
+		if message.Action == "announce" && screeningID != "" {
+			place = lookupPlacement(screeningID, visitorID)
+		}
+
+		trackerMu.Lock()
+		switch message.Action {
+		case "announce":
+			if infoHashes := message.infoHashes(); len(infoHashes) == 1 {
+				trackerAnnounce(conn, place, message, infoHashes[0])
+			}
+		case "scrape":
+			trackerScrape(conn, message)
+		}
+		trackerMu.Unlock()
+	}
+}
+
+// Sign the screening and visitor of a tracker URL so peers can't claim to
+// sit in someone else's lobby or seat
+func trackerSignature(screeningID, visitorID string) string {
+	mac := hmac.New(sha256.New, []byte(config.JWTSecret))
+	mac.Write([]byte("tracker:" + screeningID + ":" + visitorID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Look up the lobby and seat of a tracker peer's visitor. Takes mu, so must
+// not be called with trackerMu held.
+func lookupPlacement(screeningID, visitorID string) peerPlacement {
+	mu.Lock()
+	defer mu.Unlock()
+
+	screening, exists := screenings[screeningID]
+	if !exists {
+		return peerPlacement{}
+	}
+	place := peerPlacement{screeningID: screeningID, scheduleID: screening.ScheduleID}
+	if visitor, exists := visitors[visitorID]; exists && visitor.ScreeningID == screeningID && visitor.Seat != nil {
+		seat := *visitor.Seat
+		place.seat = &seat
+	}
+	return place
+}
+
+// Get the URL of the embedded tracker as seen by the client. The signed
+// screening and visitor let the tracker match peers sitting together.
+func trackerURL(c *gin.Context, screeningID, visitorID string) string {
+	query := url.Values{"screening": {screeningID}}
+	if visitorID != "" {
+		query.Set("visitor", visitorID)
+	}
+	query.Set("sig", trackerSignature(screeningID, visitorID))
+	return strings.Replace(publicURL(c), "http", "ws", 1) + "/announce?" + query.Encode()
+}
+
+// Get the instance's base URL as seen by the client
+func publicURL(c *gin.Context) string {
+	if config.PublicURL != "" {
+		return strings.TrimSuffix(config.PublicURL, "/")
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// Append a parameter to a magnet link unless it is already there
+func withMagnetParam(magnetLink, key, value string) string {
+	param := key + "=" + url.QueryEscape(value)
+	if strings.Contains(magnetLink, param) {
+		return magnetLink
+	}
+	return magnetLink + "&" + param
+}
+
+// Copy a screening for a response, announcing to this instance's tracker,
+// listing its web seed when the film is in the media library and pointing
+// at the film's subtitles
+func screeningResponse(c *gin.Context, screening *Screening, visitorID string) *Screening {
+	response := *screening
+	response.MagnetLink = withMagnetParam(screening.MagnetLink, "tr", trackerURL(c, screening.ID, visitorID))
+	if screening.Files != nil {
+		response.MagnetLink = withMagnetParam(response.MagnetLink, "so", screening.Files.selectOnly())
+	}
+	if film, exists := films[screening.FilmID]; exists {
+		response.Subtitles = subtitleTracks(c, film.ID)
+
+		if seed := webSeedURL(c, film); seed != "" {
+			response.MagnetLink = withMagnetParam(response.MagnetLink, "ws", seed)
+		}
+
+		// Films with a .torrent ship it so metadata never depends on peers
+		if film.TorrentFile != "" {
+			response.MagnetLink = withMagnetParam(response.MagnetLink, "xs", publicURL(c)+"/torrents/"+film.TorrentFile)
+		}
+	}
+	return &response
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resolve a path inside the media library, refusing anything that escapes it
+func mediaFile(relative string) (string, error) {
+	if config.MediaDir == "" {
+		return "", fmt.Errorf("no media library configured")
+	}
+
+	cleaned := path.Clean("/" + relative)
+	full := filepath.Join(config.MediaDir, filepath.FromSlash(cleaned))
+	rel, err := filepath.Rel(config.MediaDir, full)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("path outside the media library")
+	}
+	return full, nil
+}
+
+// Check that a film's media path exists in the library, returning an error message if not
+func checkMediaPath(relative string) string {
+	if relative == "" {
+		return ""
+	}
+	full, err := mediaFile(relative)
+	if err != nil {
+		return "Invalid media path: " + err.Error()
+	}
+	if _, err := os.Stat(full); err != nil {
+		return "Media path not found in the library"
+	}
+	return ""
+}
+
+// Get the BEP-19 web seed URL for a film in the media library, or "" if it
+// has none. A single file is seeded at its own URL; a directory holding a
+// multi-file torrent is seeded at its parent with a trailing slash, so
+// clients append the torrent name, which must match the directory's name.
+func webSeedURL(c *gin.Context, film *Film) string {
+	if film.MediaPath == "" {
+		return ""
+	}
+	full, err := mediaFile(film.MediaPath)
+	if err != nil {
+		return ""
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return ""
+	}
+
+	relative := strings.Trim(path.Clean("/"+film.MediaPath), "/")
+	if info.IsDir() {
+		relative = path.Dir(relative) + "/"
+		if relative == "./" {
+			relative = ""
+		}
+	}
+
+	escaped := (&url.URL{Path: relative}).EscapedPath()
+	return publicURL(c) + "/webseed/" + escaped
+}
+
+// Serve a file from the media library as an HTTP web seed, with Range support
+func serveWebSeed(c *gin.Context) {
+	full, err := mediaFile(c.Param("path"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
+	file, err := os.Open(full)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
+	// Browsers fetch pieces cross-origin when the page is served elsewhere
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("Access-Control-Expose-Headers", "Content-Range, Content-Length, Accept-Ranges")
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Outgoing WebSocket limits. A client that can't take a message within the
+// write timeout, or falls a whole buffer behind, is disconnected.
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsSendBuffer   = 256
+)
+
+// wsWriter owns all writes to one WebSocket connection, so callers holding
+// mu only ever enqueue
+type wsWriter struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+var (
+	writersMu sync.Mutex // Guards writers; never held while taking mu
+	writers   = make(map[*websocket.Conn]*wsWriter)
+)
+
+// Start the writer goroutine of a new connection
+func startWriter(conn *websocket.Conn) {
+	writer := &wsWriter{conn: conn, send: make(chan []byte, wsSendBuffer)}
+
+	writersMu.Lock()
+	writers[conn] = writer
+	writersMu.Unlock()
+
+	go writer.run()
+}
+
+// Stop a connection's writer once it is closing. Messages still queued are dropped.
+func stopWriter(conn *websocket.Conn) {
+	writersMu.Lock()
+	defer writersMu.Unlock()
+
+	if writer, exists := writers[conn]; exists {
+		delete(writers, conn)
+		close(writer.send)
+	}
+}
+
+// Write queued messages until the connection is stopped. After a failed
+// write the connection is closed and the rest of the queue is discarded.
+func (w *wsWriter) run() {
+	failed := false
+	for data := range w.send {
+		if failed {
+			continue
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := w.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Printf("Failed to send WebSocket message: %v", err)
+			w.conn.Close()
+			failed = true
+		}
+	}
+}
+
+// Queue an encoded message for a connection without blocking. Closing the
+// connection of a client that fell too far behind lets its read loop clean up.
+func sendRaw(conn *websocket.Conn, data []byte) {
+	writersMu.Lock()
+	defer writersMu.Unlock()
+
+	writer, exists := writers[conn]
+	if !exists {
+		return
+	}
+	select {
+	case writer.send <- data:
+	default:
+		log.Printf("WebSocket send buffer full, closing slow connection")
+		conn.Close()
+	}
+}
+
+// Encode a message and queue it for a connection. Encoding happens right
+// away, under the caller's lock, so the message can't change before it is
+// written.
+func sendJSON(conn *websocket.Conn, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to encode WebSocket message: %v", err)
+		return
+	}
+	sendRaw(conn, data)
+}